@@ -0,0 +1,245 @@
+// Package backendtest implements the conformance tests shared by the backend.Provider implementations.
+package backendtest
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc/backend"
+)
+
+// EventTimeout is the duration WaitEvent waits for an event.
+var EventTimeout = time.Second * 5
+
+// Factory returns a new provider of an empty store, closed by the cleanup of t.
+type Factory func(t *testing.T) backend.Provider
+
+// Run runs the conformance tests against the providers of the factory.
+func Run(t *testing.T, newProvider Factory) {
+	t.Run("SetGetDelete", func(t *testing.T) {
+		testSetGetDelete(t, newProvider(t))
+	})
+	t.Run("Incr", func(t *testing.T) {
+		testIncr(t, newProvider(t))
+	})
+	t.Run("Watch", func(t *testing.T) {
+		testWatch(t, newProvider(t))
+	})
+	t.Run("Unwatch", func(t *testing.T) {
+		testUnwatch(t, newProvider(t))
+	})
+	t.Run("KeepAlive", func(t *testing.T) {
+		testKeepAlive(t, newProvider(t))
+	})
+	t.Run("UpdateKeepAlive", func(t *testing.T) {
+		testUpdateKeepAlive(t, newProvider(t))
+	})
+}
+
+// WaitEvent returns the next event of the channel, or fails the test after EventTimeout.
+func WaitEvent(t testing.TB, ch backend.EventChan) *backend.WatchEvent {
+	t.Helper()
+	select {
+	case evt := <-ch:
+		return evt
+	case <-time.After(EventTimeout):
+		t.Fatal("watch event timeout")
+		return nil
+	}
+}
+
+// Get returns the sorted key=value pairs of the key or directory.
+func Get(t testing.TB, p backend.Provider, key string, dir bool) []string {
+	t.Helper()
+	kvs, err := p.Get(key, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		pairs = append(pairs, kv.Key+"="+kv.Value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+func expect(t testing.TB, actual []string, expected ...string) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatal("expect:", expected, "actual:", actual)
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			t.Fatal("expect:", expected, "actual:", actual)
+		}
+	}
+}
+
+// expectEvent waits for the event, the state of the key is checked instead on the Reset
+// of the watch, sent by the providers resyncing the changes.
+func expectEvent(t testing.TB, p backend.Provider, ch backend.EventChan, typ backend.EventType, key, value string) {
+	t.Helper()
+	evt := WaitEvent(t, ch)
+	if evt.Type == backend.Reset {
+		if typ == backend.Put {
+			expect(t, Get(t, p, key, false), key+"="+value)
+		} else {
+			expect(t, Get(t, p, key, false))
+		}
+		return
+	}
+	if evt.Type != typ || evt.Key != key || typ == backend.Put && evt.Value != value {
+		t.Fatal("expect:", typ, key, value, "actual:", evt)
+	}
+}
+
+func testSetGetDelete(t *testing.T, p backend.Provider) {
+	for k, v := range map[string]string{
+		"/backendtest/kv/A":    "a",
+		"/backendtest/kv/ES/B": "b",
+		"/backendtest/kv2/C":   "c",
+	} {
+		if err := p.Set(k, v, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect(t, Get(t, p, "/backendtest/kv/A", false), "/backendtest/kv/A=a")
+	expect(t, Get(t, p, "/backendtest/kv/", true), "/backendtest/kv/A=a", "/backendtest/kv/ES/B=b")
+	expect(t, Get(t, p, "/backendtest/none", false))
+
+	if err := p.Delete("/backendtest/kv/A", false); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, Get(t, p, "/backendtest/kv/A", false))
+	if err := p.Delete("/backendtest/kv/", true); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, Get(t, p, "/backendtest/kv/", true))
+	expect(t, Get(t, p, "/backendtest/", true), "/backendtest/kv2/C=c")
+}
+
+func testIncr(t *testing.T, p backend.Provider) {
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.Incr("/backendtest/incr"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	num, err := p.Incr("/backendtest/incr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 11 {
+		t.Fatal("actual:", num)
+	}
+}
+
+func testWatch(t *testing.T, p backend.Provider) {
+	ch, err := p.Watch("/backendtest/watch/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Set("/backendtest/watch/ES/A", "a", 0); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, p, ch, backend.Put, "/backendtest/watch/ES/A", "a")
+	if err = p.Set("/backendtest/watch/ES/A", "b", 0); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, p, ch, backend.Put, "/backendtest/watch/ES/A", "b")
+	if err = p.Delete("/backendtest/watch/ES/A", false); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, p, ch, backend.Delete, "/backendtest/watch/ES/A", "")
+}
+
+func testUnwatch(t *testing.T, p backend.Provider) {
+	u, ok := p.(backend.Unwatcher)
+	if !ok {
+		t.Skip("provider without Unwatch")
+	}
+	stopped, err := p.Watch("/backendtest/unwatch/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := p.Watch("/backendtest/unwatch/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Unwatch(stopped); err != nil {
+		t.Fatal(err)
+	}
+	// Unwatch is idempotent.
+	if err = u.Unwatch(stopped); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Set("/backendtest/unwatch/A", "a", 0); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, p, ch, backend.Put, "/backendtest/unwatch/A", "a")
+	select {
+	case evt := <-stopped:
+		t.Fatal("event after Unwatch:", evt)
+	default:
+	}
+}
+
+func testKeepAlive(t *testing.T, p backend.Provider) {
+	ch, err := p.Watch("/backendtest/service/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.KeepAlive("/backendtest/service/node1", "n1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, p, ch, backend.Put, "/backendtest/service/node1", "n1")
+	// Still alive after the ttl.
+	time.Sleep(time.Millisecond * 1500)
+	expect(t, Get(t, p, "/backendtest/service/node1", false), "/backendtest/service/node1=n1")
+}
+
+func testUpdateKeepAlive(t *testing.T, p backend.Provider) {
+	updater, ok := p.(backend.KeepAliveUpdater)
+	if !ok {
+		t.Fatal("provider without KeepAliveUpdater")
+	}
+	if err := updater.UpdateKeepAlive("/backendtest/update/node1", "n1"); err != backend.ErrNotKeptAlive {
+		t.Fatal("actual:", err)
+	}
+	ch, err := p.Watch("/backendtest/update/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.KeepAlive("/backendtest/update/node1", "n1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, p, ch, backend.Put, "/backendtest/update/node1", "n1")
+	if err = updater.UpdateKeepAlive("/backendtest/update/node1", "n2"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, p, ch, backend.Put, "/backendtest/update/node1", "n2")
+	// The refreshes keep the updated value.
+	time.Sleep(time.Millisecond * 1500)
+	expect(t, Get(t, p, "/backendtest/update/node1", false), "/backendtest/update/node1=n2")
+
+	if err = updater.StopKeepAlive("/backendtest/update/node1"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, Get(t, p, "/backendtest/update/node1", false))
+	// Not rewritten after stopped.
+	time.Sleep(time.Millisecond * 1500)
+	expect(t, Get(t, p, "/backendtest/update/node1", false))
+	if err = updater.UpdateKeepAlive("/backendtest/update/node1", "n3"); err != backend.ErrNotKeptAlive {
+		t.Fatal("actual:", err)
+	}
+	if err = updater.StopKeepAlive("/backendtest/update/node1"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,450 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	// Consul rejects session TTLs below 10 seconds, the shorter ttls of Set and KeepAlive
+	// are raised to it, so the keys outlive their ttls up to MinSessionTTL.
+	MinSessionTTL = time.Second * 10
+	// Max duration of a blocking query.
+	WatchWaitTime = time.Minute
+)
+
+// alive is a key kept alive by a session.
+type alive struct {
+	value   string
+	session string
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+type Consul struct {
+	watches backend.Watches
+	mu      sync.Mutex
+	alive   map[string]*alive
+	wg      sync.WaitGroup
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	*api.Client
+}
+
+func NewProvider(ctx context.Context, address, token string) (backend.Provider, error) {
+	cfg := api.DefaultConfig()
+	cfg.Address = address
+	cfg.Token = token
+	cli, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &Consul{
+		Client: cli,
+		alive:  make(map[string]*alive),
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	return p, nil
+}
+
+// Type returns the provider type.
+func (p *Consul) Type() string {
+	return backend.Consul
+}
+
+// Set value for the specified key with a specified ttl, at least MinSessionTTL.
+func (p *Consul) Set(key, value string, ttl time.Duration) error {
+	pair := &api.KVPair{
+		Key:   consulKey(key),
+		Value: []byte(value),
+	}
+	if ttl > 0 {
+		session, err := p.createSession(ttl)
+		if err != nil {
+			return err
+		}
+		pair.Session = session
+		return p.acquire(pair)
+	}
+
+	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	defer cancel()
+	_, err := p.KV().Put(pair, p.writeOptions(ctx))
+	return err
+}
+
+// Get the value of the specified key or directory.
+func (p *Consul) Get(key string, dir bool) (backend.KVPairs, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.ReadTimeout)
+	defer cancel()
+	pairs, _, err := p.query(ctx, key, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	kvs := make(backend.KVPairs, 0, len(pairs))
+	for _, pair := range pairs {
+		kvs = append(kvs, &backend.KVPair{
+			Key:   grcKey(key, pair.Key),
+			Value: string(pair.Value),
+		})
+	}
+	return kvs, nil
+}
+
+// Incr invokes an atomic value increase for the specified key.
+func (p *Consul) Incr(key string) (int64, error) {
+	for {
+		select {
+		case <-p.ctx.Done():
+			return 0, p.ctx.Err()
+		default:
+		}
+
+		ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+		pair, _, err := p.KV().Get(consulKey(key), p.queryOptions(ctx, 0))
+		if err != nil {
+			cancel()
+			return 0, err
+		}
+		num, index := int64(0), uint64(0)
+		if pair != nil {
+			num, _ = strconv.ParseInt(string(pair.Value), 10, 64)
+			index = pair.ModifyIndex
+		}
+		num++
+		// ModifyIndex 0 means create only if the key does not exist.
+		ok, _, err := p.KV().CAS(&api.KVPair{
+			Key:         consulKey(key),
+			Value:       []byte(strconv.FormatInt(num, 10)),
+			ModifyIndex: index,
+		}, p.writeOptions(ctx))
+		cancel()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return num, nil
+		}
+	}
+}
+
+// Delete the specified key or directory.
+func (p *Consul) Delete(key string, dir bool) error {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	defer cancel()
+	var err error
+	if dir {
+		_, err = p.KV().DeleteTree(consulKey(key), p.writeOptions(ctx))
+	} else {
+		_, err = p.KV().Delete(consulKey(key), p.writeOptions(ctx))
+	}
+	return err
+}
+
+// Watch for changes of the specified key or directory.
+func (p *Consul) Watch(key string, dir bool) (backend.EventChan, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.ReadTimeout)
+	defer cancel()
+	pairs, index, err := p.query(ctx, key, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	//
+	watchCtx, eventsChan := p.watches.Add(p.ctx)
+	//
+	go p.watch(watchCtx, key, dir, index, snapshot(pairs), eventsChan)
+
+	return eventsChan, nil
+}
+
+// Unwatch stops the watch of the channel returned by Watch.
+func (p *Consul) Unwatch(ch backend.EventChan) error {
+	p.watches.Remove(ch)
+	return nil
+}
+
+func (p *Consul) watch(ctx context.Context, key string, dir bool, index uint64, last map[string]*api.KVPair, eventsChan backend.EventChan) {
+	defer p.watches.Remove(eventsChan)
+	for {
+		pairs, newIndex, err := p.query(ctx, key, dir, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Println("grc: consul watch error, ", err.Error())
+			time.Sleep(backend.RetryTimeout)
+			continue
+		}
+		//
+		current := snapshot(pairs)
+		if newIndex < index {
+			// The index went backwards, the server state was reset.
+			log.Println("grc: consul index reset")
+			reset := &backend.WatchEvent{
+				Type: backend.Reset,
+				KVPair: backend.KVPair{
+					Key: key,
+				},
+			}
+			if !backend.SendEvent(ctx, eventsChan, reset) {
+				return
+			}
+			last, index = current, 0
+			continue
+		}
+		for k, pair := range current {
+			if prev, ok := last[k]; ok && prev.ModifyIndex == pair.ModifyIndex {
+				continue
+			}
+			if !backend.SendEvent(ctx, eventsChan, newEvent(backend.Put, key, pair)) {
+				return
+			}
+		}
+		for k, pair := range last {
+			if _, ok := current[k]; ok {
+				continue
+			}
+			if !backend.SendEvent(ctx, eventsChan, newEvent(backend.Delete, key, pair)) {
+				return
+			}
+		}
+		last, index = current, newIndex
+	}
+}
+
+// KeepAlive sets value and updates the ttl for the specified key, at least MinSessionTTL.
+// Consul invalidates the sessions up to twice the ttl after the last renewal.
+func (p *Consul) KeepAlive(key, value string, ttl time.Duration) error {
+	if err := p.StopKeepAlive(key); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(p.ctx)
+	session, err := p.keepAlive(ctx, key, value, ttl, false)
+	if err != nil {
+		cancel()
+		return err
+	}
+	a := &alive{
+		value:   value,
+		session: session,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	p.mu.Lock()
+	p.alive[key] = a
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(a.done)
+
+		for {
+			err := p.Session().RenewPeriodic(sessionTTL(ttl).String(), session, p.writeOptions(ctx), nil)
+			if ctx.Err() != nil {
+				// Stopping, remove the key and release the session.
+				_, err = p.KV().Delete(consulKey(key), nil)
+				if err != nil {
+					log.Println("grc: consul KeepAlive stopping, ", err.Error())
+				}
+				_, _ = p.Session().Destroy(session, nil)
+				return
+			}
+			// session expired, retry
+			log.Println("grc: consul session renew failed, ", err)
+			p.mu.Lock()
+			value := a.value
+			p.mu.Unlock()
+			session, _ = p.keepAlive(ctx, key, value, ttl, true)
+			p.mu.Lock()
+			a.session = session
+			p.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// UpdateKeepAlive updates the value of the key kept alive, under the same session.
+func (p *Consul) UpdateKeepAlive(key, value string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a, ok := p.alive[key]
+	if !ok {
+		return backend.ErrNotKeptAlive
+	}
+	err := p.acquire(&api.KVPair{
+		Key:     consulKey(key),
+		Value:   []byte(value),
+		Session: a.session,
+	})
+	if err != nil {
+		return err
+	}
+	a.value = value
+	return nil
+}
+
+// StopKeepAlive stops keeping the key alive and deletes it.
+func (p *Consul) StopKeepAlive(key string) error {
+	p.mu.Lock()
+	a, ok := p.alive[key]
+	delete(p.alive, key)
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	a.cancel()
+	<-a.done
+	return nil
+}
+
+// Close the provider connection.
+func (p *Consul) Close() error {
+	p.cancel()
+	// Wait for the keys kept alive to be deleted.
+	p.wg.Wait()
+	return nil
+}
+
+func (p *Consul) keepAlive(ctx context.Context, key, value string, ttl time.Duration, withRetry bool) (string, error) {
+Retry:
+	// create session
+	session, err := p.createSession(ttl)
+	if err != nil {
+		if withRetry && ctx.Err() == nil {
+			time.Sleep(backend.RetryTimeout)
+			goto Retry
+		}
+		return "", err
+	}
+
+	// put value with session
+	err = p.acquire(&api.KVPair{
+		Key:     consulKey(key),
+		Value:   []byte(value),
+		Session: session,
+	})
+	if err != nil {
+		if withRetry && ctx.Err() == nil {
+			time.Sleep(backend.RetryTimeout)
+			goto Retry
+		}
+		return "", err
+	}
+
+	return session, nil
+}
+
+func (p *Consul) createSession(ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	defer cancel()
+	session, _, err := p.Session().Create(&api.SessionEntry{
+		Name:      "grc",
+		TTL:       sessionTTL(ttl).String(),
+		Behavior:  api.SessionBehaviorDelete,
+		LockDelay: time.Millisecond,
+	}, p.writeOptions(ctx))
+	return session, err
+}
+
+func (p *Consul) acquire(pair *api.KVPair) error {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	defer cancel()
+	ok, _, err := p.KV().Acquire(pair, p.writeOptions(ctx))
+	if err != nil || ok {
+		return err
+	}
+	// The key is held by another session, replace it.
+	if _, err = p.KV().Delete(pair.Key, p.writeOptions(ctx)); err != nil {
+		return err
+	}
+	ok, _, err = p.KV().Acquire(pair, p.writeOptions(ctx))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("grc: consul acquire %s failed", pair.Key)
+	}
+	return nil
+}
+
+func (p *Consul) query(ctx context.Context, key string, dir bool, index uint64) (api.KVPairs, uint64, error) {
+	opts := p.queryOptions(ctx, index)
+	if dir {
+		pairs, meta, err := p.KV().List(consulKey(key), opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		return pairs, meta.LastIndex, nil
+	}
+	pair, meta, err := p.KV().Get(consulKey(key), opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	if pair == nil {
+		return api.KVPairs{}, meta.LastIndex, nil
+	}
+	return api.KVPairs{pair}, meta.LastIndex, nil
+}
+
+func (p *Consul) queryOptions(ctx context.Context, index uint64) *api.QueryOptions {
+	opts := &api.QueryOptions{
+		WaitIndex: index,
+	}
+	if index > 0 {
+		opts.WaitTime = WatchWaitTime
+	}
+	return opts.WithContext(ctx)
+}
+
+func (p *Consul) writeOptions(ctx context.Context) *api.WriteOptions {
+	return (&api.WriteOptions{}).WithContext(ctx)
+}
+
+// sessionTTL returns the ttl raised to MinSessionTTL.
+func sessionTTL(ttl time.Duration) time.Duration {
+	if ttl < MinSessionTTL {
+		return MinSessionTTL
+	}
+	return ttl
+}
+
+func snapshot(pairs api.KVPairs) map[string]*api.KVPair {
+	m := make(map[string]*api.KVPair, len(pairs))
+	for _, pair := range pairs {
+		m[pair.Key] = pair
+	}
+	return m
+}
+
+func newEvent(typ backend.EventType, key string, pair *api.KVPair) *backend.WatchEvent {
+	return &backend.WatchEvent{
+		Type: typ,
+		KVPair: backend.KVPair{
+			Key:   grcKey(key, pair.Key),
+			Value: string(pair.Value),
+		},
+	}
+}
+
+// Consul keys must not begin with a '/'.
+func consulKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+// Restore the leading '/' stripped by consulKey.
+func grcKey(requested, key string) string {
+	if strings.HasPrefix(requested, "/") {
+		return "/" + key
+	}
+	return key
+}
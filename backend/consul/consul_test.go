@@ -0,0 +1,311 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"github.com/appootb/grc/backend/backendtest"
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeConsul implements the subset of the Consul KV/session HTTP API used by the provider.
+type fakeConsul struct {
+	sync.Mutex
+	index    uint64
+	seq      int
+	kvs      map[string]*api.KVPair
+	sessions map[string]string
+	changed  chan struct{}
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		index:    1,
+		kvs:      make(map[string]*api.KVPair),
+		sessions: make(map[string]string),
+		changed:  make(chan struct{}),
+	}
+}
+
+// bump must be called with the lock held.
+func (f *fakeConsul) bump() uint64 {
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+	return f.index
+}
+
+// expireSessions invalidates every session, deleting the keys they hold.
+func (f *fakeConsul) expireSessions() {
+	f.Lock()
+	defer f.Unlock()
+	for id := range f.sessions {
+		f.destroy(id)
+	}
+}
+
+// destroy must be called with the lock held.
+func (f *fakeConsul) destroy(id string) {
+	delete(f.sessions, id)
+	for k, pair := range f.kvs {
+		if pair.Session == id {
+			delete(f.kvs, k)
+		}
+	}
+	f.bump()
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		f.serveKV(w, r, strings.TrimPrefix(r.URL.Path, "/v1/kv/"))
+	case strings.HasPrefix(r.URL.Path, "/v1/session/"):
+		f.serveSession(w, r, strings.TrimPrefix(r.URL.Path, "/v1/session/"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeConsul) serveKV(w http.ResponseWriter, r *http.Request, key string) {
+	q := r.URL.Query()
+	_, recurse := q["recurse"]
+
+	switch r.Method {
+	case http.MethodGet:
+		waitIndex, _ := strconv.ParseUint(q.Get("index"), 10, 64)
+		waitTime, _ := time.ParseDuration(q.Get("wait"))
+		deadline := time.After(waitTime)
+		f.Lock()
+		for waitIndex > 0 && f.index == waitIndex {
+			ch := f.changed
+			f.Unlock()
+			select {
+			case <-ch:
+			case <-deadline:
+				waitIndex = 0
+			case <-r.Context().Done():
+				return
+			}
+			f.Lock()
+		}
+		var pairs api.KVPairs
+		for k, pair := range f.kvs {
+			if k == key || recurse && strings.HasPrefix(k, key) {
+				v := *pair
+				pairs = append(pairs, &v)
+			}
+		}
+		index := f.index
+		f.Unlock()
+
+		sort.Slice(pairs, func(i, j int) bool {
+			return pairs[i].Key < pairs[j].Key
+		})
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+		w.Header().Set("X-Consul-LastContact", "0")
+		w.Header().Set("X-Consul-KnownLeader", "true")
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(pairs)
+
+	case http.MethodPut:
+		value, _ := ioutil.ReadAll(r.Body)
+		f.Lock()
+		defer f.Unlock()
+		pair, exists := f.kvs[key]
+		if cas := q.Get("cas"); cas != "" {
+			index, _ := strconv.ParseUint(cas, 10, 64)
+			if index == 0 && exists || index != 0 && (!exists || pair.ModifyIndex != index) {
+				_, _ = w.Write([]byte("false"))
+				return
+			}
+		}
+		session := ""
+		if id := q.Get("acquire"); id != "" {
+			if _, ok := f.sessions[id]; !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if exists && pair.Session != "" && pair.Session != id {
+				_, _ = w.Write([]byte("false"))
+				return
+			}
+			session = id
+		} else if exists {
+			session = pair.Session
+		}
+		index := f.bump()
+		created := index
+		if exists {
+			created = pair.CreateIndex
+		}
+		f.kvs[key] = &api.KVPair{
+			Key:         key,
+			Value:       value,
+			Session:     session,
+			CreateIndex: created,
+			ModifyIndex: index,
+		}
+		_, _ = w.Write([]byte("true"))
+
+	case http.MethodDelete:
+		f.Lock()
+		defer f.Unlock()
+		for k := range f.kvs {
+			if k == key || recurse && strings.HasPrefix(k, key) {
+				delete(f.kvs, k)
+			}
+		}
+		f.bump()
+		_, _ = w.Write([]byte("true"))
+	}
+}
+
+func (f *fakeConsul) serveSession(w http.ResponseWriter, r *http.Request, path string) {
+	f.Lock()
+	defer f.Unlock()
+
+	switch {
+	case path == "create":
+		var entry struct{ TTL string }
+		_ = json.NewDecoder(r.Body).Decode(&entry)
+		f.seq++
+		id := "session-" + strconv.Itoa(f.seq)
+		f.sessions[id] = entry.TTL
+		_ = json.NewEncoder(w).Encode(map[string]string{"ID": id})
+
+	case strings.HasPrefix(path, "renew/"):
+		id := strings.TrimPrefix(path, "renew/")
+		ttl, ok := f.sessions[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]*api.SessionEntry{{ID: id, TTL: ttl}})
+
+	case strings.HasPrefix(path, "destroy/"):
+		f.destroy(strings.TrimPrefix(path, "destroy/"))
+		_, _ = w.Write([]byte("true"))
+	}
+}
+
+func init() {
+	// Renewals of the sessions are MinSessionTTL/2 apart.
+	backendtest.EventTimeout = MinSessionTTL
+}
+
+func newTestProvider(t *testing.T) (*fakeConsul, backend.Provider) {
+	fake := newFakeConsul()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	p, err := NewProvider(context.Background(), srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+	return fake, p
+}
+
+func TestConsul(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Provider {
+		_, p := newTestProvider(t)
+		return p
+	})
+}
+
+func TestConsul_WatchReset(t *testing.T) {
+	fake, p := newTestProvider(t)
+
+	ch, err := p.Watch("/test/watch/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Set("/test/watch/k", "v2", 0); err != nil {
+		t.Fatal(err)
+	}
+	backendtest.WaitEvent(t, ch)
+	// Simulate a server state reset.
+	fake.Lock()
+	fake.index = 0
+	fake.bump()
+	fake.Unlock()
+	// A Reset of the watch, not of each key.
+	evt := backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Reset || evt.Key != "/test/watch/" {
+		t.Fatal("actual:", evt)
+	}
+	// Reset to an empty prefix, after the index of the watch advanced.
+	for i := 0; i < 3; i++ {
+		if err = p.Set("/test/other", "v", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond * 100)
+	fake.Lock()
+	fake.kvs = make(map[string]*api.KVPair)
+	fake.index = 0
+	fake.bump()
+	fake.Unlock()
+	evt = backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Reset || evt.Key != "/test/watch/" {
+		t.Fatal("actual:", evt)
+	}
+}
+
+func TestSessionTTL(t *testing.T) {
+	if ttl := sessionTTL(time.Second * 3); ttl != MinSessionTTL {
+		t.Fatal("actual:", ttl)
+	}
+	if ttl := sessionTTL(time.Minute); ttl != time.Minute {
+		t.Fatal("actual:", ttl)
+	}
+}
+
+func TestConsul_KeepAlive(t *testing.T) {
+	fake, p := newTestProvider(t)
+
+	ch, err := p.Watch("/test/node", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.KeepAlive("/test/node", "n1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if evt := backendtest.WaitEvent(t, ch); evt.Type != backend.Put || evt.Value != "n1" {
+		t.Fatal("actual:", evt)
+	}
+	if err = p.Set("/test/ttl", "v", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Session expired, the ttl key is removed and the node key is restored
+	// on the next renewal.
+	fake.expireSessions()
+	if evt := backendtest.WaitEvent(t, ch); evt.Type != backend.Delete {
+		t.Fatal("actual:", evt)
+	}
+	if evt := backendtest.WaitEvent(t, ch); evt.Type != backend.Put || evt.Value != "n1" {
+		t.Fatal("actual:", evt)
+	}
+	kvs, err := p.Get("/test/ttl", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 0 {
+		t.Fatal("actual:", kvs)
+	}
+}
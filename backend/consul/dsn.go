@@ -0,0 +1,26 @@
+package consul
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/appootb/grc/backend"
+)
+
+func init() {
+	backend.Register(backend.Consul, Open)
+}
+
+// Open creates a Consul provider from the DSN,
+// consul://token@127.0.0.1:8500/base
+func Open(ctx context.Context, dsn *url.URL) (backend.Provider, string, error) {
+	token := dsn.Query().Get("token")
+	if token == "" {
+		token = dsn.User.Username()
+	}
+	provider, err := NewProvider(ctx, dsn.Host, token)
+	if err != nil {
+		return nil, "", err
+	}
+	return provider, backend.BasePath(dsn), nil
+}
@@ -0,0 +1,224 @@
+package backend
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Op holds the options of a ContextProvider call.
+type Op struct {
+	Prefix bool
+	TTL    time.Duration
+}
+
+// OpOption sets an option of a ContextProvider call.
+type OpOption func(*Op)
+
+// NewOp returns the options applied.
+func NewOp(opts ...OpOption) *Op {
+	op := &Op{}
+	for _, opt := range opts {
+		opt(op)
+	}
+	return op
+}
+
+// WithPrefix operates on the keys with the prefix, a directory.
+func WithPrefix() OpOption {
+	return func(op *Op) {
+		op.Prefix = true
+	}
+}
+
+// WithTTL sets the ttl of the key.
+func WithTTL(ttl time.Duration) OpOption {
+	return func(op *Op) {
+		op.TTL = ttl
+	}
+}
+
+// ContextProvider interface, each call is bounded by the ctx.
+type ContextProvider interface {
+	// Type returns the provider type.
+	Type() string
+
+	// Set value for the specified key, WithTTL sets the ttl.
+	Set(ctx context.Context, key, value string, opts ...OpOption) error
+
+	// Get the value of the specified key, or directory WithPrefix.
+	Get(ctx context.Context, key string, opts ...OpOption) (KVPairs, error)
+
+	// Incr invokes an atomic value increase for the specified key.
+	Incr(ctx context.Context, key string) (int64, error)
+
+	// Delete the specified key, or directory WithPrefix.
+	Delete(ctx context.Context, key string, opts ...OpOption) error
+
+	// Watch for changes of the specified key, or directory WithPrefix,
+	// until the ctx is done, then the channel is closed.
+	Watch(ctx context.Context, key string, opts ...OpOption) (EventChan, error)
+
+	// KeepAlive sets value and updates the ttl for the specified key,
+	// the ctx bounds the first write, the key is kept alive until the provider is closed.
+	KeepAlive(ctx context.Context, key, value string, ttl time.Duration) error
+
+	// Close the provider connection.
+	Close() error
+}
+
+// ContextSupport is implemented by the providers which support contexts natively.
+type ContextSupport interface {
+	// Context returns the context-aware view of the provider.
+	Context() ContextProvider
+}
+
+// NewContextProvider returns the context-aware provider,
+// calls of the providers without native support return when the ctx is done.
+// Their Set, Incr and Delete may still take effect after the ctx is done,
+// the keys kept alive and the watches created too late are stopped.
+func NewContextProvider(p Provider) ContextProvider {
+	if cp, ok := p.(ContextSupport); ok {
+		return cp.Context()
+	}
+	return &contextAdapter{
+		p: p,
+	}
+}
+
+type contextAdapter struct {
+	p Provider
+}
+
+func (a *contextAdapter) Type() string {
+	return a.p.Type()
+}
+
+func (a *contextAdapter) Set(ctx context.Context, key, value string, opts ...OpOption) error {
+	op := NewOp(opts...)
+	return a.do(ctx, func() error {
+		return a.p.Set(key, value, op.TTL)
+	}, nil)
+}
+
+func (a *contextAdapter) Get(ctx context.Context, key string, opts ...OpOption) (KVPairs, error) {
+	var kvs KVPairs
+	op := NewOp(opts...)
+	err := a.do(ctx, func() (err error) {
+		kvs, err = a.p.Get(key, op.Prefix)
+		return
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return kvs, nil
+}
+
+func (a *contextAdapter) Incr(ctx context.Context, key string) (int64, error) {
+	var num int64
+	err := a.do(ctx, func() (err error) {
+		num, err = a.p.Incr(key)
+		return
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+	return num, nil
+}
+
+func (a *contextAdapter) Delete(ctx context.Context, key string, opts ...OpOption) error {
+	op := NewOp(opts...)
+	return a.do(ctx, func() error {
+		return a.p.Delete(key, op.Prefix)
+	}, nil)
+}
+
+// Watch forwards the events until the ctx is done, then the underlying watch
+// is stopped if the provider is an Unwatcher, or when the provider is closed.
+func (a *contextAdapter) Watch(ctx context.Context, key string, opts ...OpOption) (EventChan, error) {
+	var ch EventChan
+	op := NewOp(opts...)
+	err := a.do(ctx, func() (err error) {
+		ch, err = a.p.Watch(key, op.Prefix)
+		return
+	}, func() {
+		a.unwatch(ch)
+	})
+	if err != nil {
+		return nil, err
+	}
+	//
+	eventsChan := make(EventChan, DefaultChanLen)
+	//
+	go func() {
+		defer close(eventsChan)
+		defer a.unwatch(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case eventsChan <- evt:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return eventsChan, nil
+}
+
+func (a *contextAdapter) KeepAlive(ctx context.Context, key, value string, ttl time.Duration) error {
+	return a.do(ctx, func() error {
+		return a.p.KeepAlive(key, value, ttl)
+	}, func() {
+		if u, ok := a.p.(KeepAliveUpdater); ok {
+			if err := u.StopKeepAlive(key); err != nil {
+				log.Println("grc: stop keep alive failed, ", key, err.Error())
+			}
+		}
+	})
+}
+
+func (a *contextAdapter) Close() error {
+	return a.p.Close()
+}
+
+// unwatch stops the underlying watch if the provider is an Unwatcher.
+func (a *contextAdapter) unwatch(ch EventChan) {
+	if u, ok := a.p.(Unwatcher); ok {
+		if err := u.Unwatch(ch); err != nil {
+			log.Println("grc: unwatch failed, ", err.Error())
+		}
+	}
+}
+
+// do returns when fn returns or the ctx is done, the results of fn must not be read
+// if the ctx is done first, then undo is invoked if fn succeeds later.
+func (a *contextAdapter) do(ctx context.Context, fn func() error, undo func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ch := make(chan error, 1)
+	go func() {
+		ch <- fn()
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		if undo != nil {
+			go func() {
+				if err := <-ch; err == nil {
+					undo()
+				}
+			}()
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,150 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"github.com/appootb/grc/backend/memory"
+)
+
+// v1Provider hides the native context support.
+type v1Provider struct {
+	backend.Provider
+}
+
+// slowProvider blocks the Get calls.
+type slowProvider struct {
+	backend.Provider
+}
+
+func (p slowProvider) Get(key string, dir bool) (backend.KVPairs, error) {
+	time.Sleep(time.Second)
+	return p.Provider.Get(key, dir)
+}
+
+func testWatchClosed(t *testing.T, cp backend.ContextProvider) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := cp.Watch(ctx, "/test/", backend.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cp.Set(context.Background(), "/test/A", "a"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case evt := <-ch:
+		if evt.Type != backend.Put || evt.Key != "/test/A" {
+			t.Fatal("actual:", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watch event timeout")
+	}
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("channel not closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed")
+	}
+}
+
+func TestContextProvider_Native(t *testing.T) {
+	p := memory.NewProvider()
+	defer p.Close()
+
+	cp := backend.NewContextProvider(p)
+	if _, ok := p.(backend.ContextSupport); !ok {
+		t.Fatal("memory provider without native context support")
+	}
+	testWatchClosed(t, cp)
+}
+
+func TestContextProvider_Adapter(t *testing.T) {
+	p := memory.NewProvider()
+	defer p.Close()
+
+	testWatchClosed(t, backend.NewContextProvider(v1Provider{p}))
+}
+
+func TestContextProvider_Deadline(t *testing.T) {
+	p := memory.NewProvider()
+	defer p.Close()
+
+	cp := backend.NewContextProvider(slowProvider{p})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	if _, err := cp.Get(ctx, "/test/A"); err != context.DeadlineExceeded {
+		t.Fatal("actual:", err)
+	}
+	if time.Since(start) > time.Millisecond*500 {
+		t.Fatal("deadline not respected")
+	}
+}
+
+// unwatchProvider records the channels unwatched.
+type unwatchProvider struct {
+	backend.Provider
+	unwatched chan backend.EventChan
+}
+
+func (p unwatchProvider) Unwatch(ch backend.EventChan) error {
+	p.unwatched <- ch
+	return nil
+}
+
+func TestContextProvider_Unwatch(t *testing.T) {
+	p := memory.NewProvider()
+	defer p.Close()
+
+	up := unwatchProvider{Provider: v1Provider{p}, unwatched: make(chan backend.EventChan, 1)}
+	testWatchClosed(t, backend.NewContextProvider(up))
+	select {
+	case <-up.unwatched:
+	case <-time.After(time.Second):
+		t.Fatal("underlying watch not stopped")
+	}
+}
+
+// slowKeepAliveProvider blocks the KeepAlive calls, without the native context support.
+type slowKeepAliveProvider struct {
+	backend.Provider
+}
+
+func (p slowKeepAliveProvider) KeepAlive(key, value string, ttl time.Duration) error {
+	time.Sleep(time.Millisecond * 200)
+	return p.Provider.KeepAlive(key, value, ttl)
+}
+
+func (p slowKeepAliveProvider) UpdateKeepAlive(key, value string) error {
+	return p.Provider.(backend.KeepAliveUpdater).UpdateKeepAlive(key, value)
+}
+
+func (p slowKeepAliveProvider) StopKeepAlive(key string) error {
+	return p.Provider.(backend.KeepAliveUpdater).StopKeepAlive(key)
+}
+
+// The key kept alive after the ctx is done is stopped.
+func TestContextProvider_KeepAliveCanceled(t *testing.T) {
+	p := memory.NewProvider()
+	defer p.Close()
+
+	cp := backend.NewContextProvider(slowKeepAliveProvider{p})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := cp.KeepAlive(ctx, "/test/A", "a", time.Second); err != context.DeadlineExceeded {
+		t.Fatal("actual:", err)
+	}
+	// Stopped once the late KeepAlive returns.
+	time.Sleep(time.Millisecond * 300)
+	if kvs, _ := p.Get("/test/A", false); len(kvs) != 0 {
+		t.Fatal("key kept alive:", kvs)
+	}
+	if err := p.(backend.KeepAliveUpdater).UpdateKeepAlive("/test/A", "b"); err != backend.ErrNotKeptAlive {
+		t.Fatal("actual:", err)
+	}
+}
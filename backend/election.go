@@ -0,0 +1,39 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNoLeader is returned if the election has no leader.
+	ErrNoLeader = errors.New("grc: election has no leader")
+)
+
+// Election of a leader among the candidates, bound to the lease of the candidate.
+type Election interface {
+	// Campaign blocks until elected or the ctx is done,
+	// the lost channel is closed when the leadership is lost.
+	Campaign(ctx context.Context, value string) (lost <-chan struct{}, err error)
+
+	// Resign gives up the leadership or the candidacy.
+	Resign(ctx context.Context) error
+
+	// Observe returns the values of the leaders in order of the changes,
+	// the channel is closed when the ctx is done.
+	Observe(ctx context.Context) <-chan string
+
+	// Close resigns and releases the lease.
+	Close() error
+}
+
+// Elector is implemented by the providers which support leader elections.
+type Elector interface {
+	// NewElection returns the election of the prefix, the leadership is lost if
+	// the candidate is not kept alive within the ttl.
+	NewElection(ctx context.Context, prefix string, ttl time.Duration) (Election, error)
+
+	// Leader returns the value of the current leader of the prefix, ErrNoLeader if none.
+	Leader(ctx context.Context, prefix string) (string, error)
+}
@@ -0,0 +1,61 @@
+package etcd
+
+import (
+	"context"
+	"time"
+
+	"github.com/appootb/grc/backend"
+)
+
+type contextProvider struct {
+	p *Etcd
+}
+
+// Context returns the context-aware view of the provider.
+func (p *Etcd) Context() backend.ContextProvider {
+	return &contextProvider{
+		p: p,
+	}
+}
+
+// Type returns the provider type.
+func (c *contextProvider) Type() string {
+	return c.p.Type()
+}
+
+// Set value for the specified key, WithTTL sets the ttl.
+func (c *contextProvider) Set(ctx context.Context, key, value string, opts ...backend.OpOption) error {
+	return c.p.set(ctx, key, value, backend.NewOp(opts...).TTL)
+}
+
+// Get the value of the specified key, or directory WithPrefix.
+func (c *contextProvider) Get(ctx context.Context, key string, opts ...backend.OpOption) (backend.KVPairs, error) {
+	return c.p.get(ctx, key, backend.NewOp(opts...).Prefix)
+}
+
+// Incr invokes an atomic value increase for the specified key.
+func (c *contextProvider) Incr(ctx context.Context, key string) (int64, error) {
+	return c.p.incr(ctx, key)
+}
+
+// Delete the specified key, or directory WithPrefix.
+func (c *contextProvider) Delete(ctx context.Context, key string, opts ...backend.OpOption) error {
+	return c.p.delete(ctx, key, backend.NewOp(opts...).Prefix)
+}
+
+// Watch for changes of the specified key, or directory WithPrefix,
+// until the ctx is done, then the channel is closed.
+func (c *contextProvider) Watch(ctx context.Context, key string, opts ...backend.OpOption) (backend.EventChan, error) {
+	return c.p.watchContext(ctx, key, backend.NewOp(opts...).Prefix)
+}
+
+// KeepAlive sets value and updates the ttl for the specified key,
+// the ctx bounds the first write, the key is kept alive until the provider is closed.
+func (c *contextProvider) KeepAlive(ctx context.Context, key, value string, ttl time.Duration) error {
+	return c.p.keepAliveContext(ctx, key, value, ttl)
+}
+
+// Close the provider connection.
+func (c *contextProvider) Close() error {
+	return c.p.Close()
+}
@@ -0,0 +1,57 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/appootb/grc/backend"
+)
+
+func init() {
+	backend.Register(backend.Etcd, Open)
+}
+
+// Open creates an etcd provider from the DSN,
+// etcd://user:pass@h1:2379,h2:2379/base?dial_timeout=5s&read_timeout=3s&write_timeout=3s
+// &auto_sync=1m&max_msg_size=4194304&cert=client.pem&key=client.key&ca=ca.pem&token=xxx
+func Open(ctx context.Context, dsn *url.URL) (backend.Provider, string, error) {
+	var opts []Option
+	query := dsn.Query()
+	for name, opt := range map[string]func(d time.Duration) Option{
+		"dial_timeout":  WithDialTimeout,
+		"read_timeout":  WithReadTimeout,
+		"write_timeout": WithWriteTimeout,
+		"auto_sync":     WithAutoSyncInterval,
+	} {
+		if query.Get(name) == "" {
+			continue
+		}
+		d, err := backend.Duration(dsn, name, 0)
+		if err != nil {
+			return nil, "", err
+		}
+		opts = append(opts, opt(d))
+	}
+	if v := query.Get("max_msg_size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, "", fmt.Errorf("grc: invalid max_msg_size %q", v)
+		}
+		opts = append(opts, WithMaxCallMsgSize(size, size))
+	}
+	if query.Get("cert") != "" || query.Get("ca") != "" {
+		opts = append(opts, WithTLSFiles(query.Get("cert"), query.Get("key"), query.Get("ca")))
+	}
+	if token := query.Get("token"); token != "" {
+		opts = append(opts, WithToken(token))
+	}
+	password, _ := dsn.User.Password()
+	provider, err := NewProvider(ctx, backend.Hosts(dsn), dsn.User.Username(), password, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+	return provider, backend.BasePath(dsn), nil
+}
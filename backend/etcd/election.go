@@ -0,0 +1,126 @@
+package etcd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// NewElection returns the election of the prefix, over the concurrency election of etcd.
+func (p *Etcd) NewElection(ctx context.Context, prefix string, ttl time.Duration) (backend.Election, error) {
+	e := &election{
+		p:      p,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+	if _, err := e.current(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Leader returns the value of the current leader of the prefix.
+func (p *Etcd) Leader(ctx context.Context, prefix string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.readTimeout)
+	defer cancel()
+	resp, err := p.Client.Get(ctx, prefix+"/", clientv3.WithFirstCreate()...)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", backend.ErrNoLeader
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+type election struct {
+	p      *Etcd
+	prefix string
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	session  *concurrency.Session
+	election *concurrency.Election
+}
+
+// current returns the election of the live session, a new session is created if lost.
+func (e *election) current(ctx context.Context) (*concurrency.Election, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.session != nil {
+		select {
+		case <-e.session.Done():
+		default:
+			return e.election, nil
+		}
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, e.p.writeTimeout)
+	defer cancel()
+	lease, err := e.p.Client.Grant(reqCtx, int64(e.ttl.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	session, err := concurrency.NewSession(e.p.Client, concurrency.WithLease(lease.ID),
+		concurrency.WithContext(e.p.ctx))
+	if err != nil {
+		return nil, err
+	}
+	e.session = session
+	e.election = concurrency.NewElection(session, e.prefix)
+	return e.election, nil
+}
+
+func (e *election) Campaign(ctx context.Context, value string) (<-chan struct{}, error) {
+	el, err := e.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = el.Campaign(ctx, value); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.session.Done(), nil
+}
+
+func (e *election) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	el := e.election
+	e.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, e.p.writeTimeout)
+	defer cancel()
+	return el.Resign(ctx)
+}
+
+func (e *election) Observe(ctx context.Context) <-chan string {
+	e.mu.Lock()
+	el := e.election
+	e.mu.Unlock()
+	ch := make(chan string, backend.DefaultChanLen)
+	go func() {
+		defer close(ch)
+
+		for resp := range el.Observe(ctx) {
+			if len(resp.Kvs) == 0 {
+				continue
+			}
+			select {
+			case ch <- string(resp.Kvs[0].Value):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (e *election) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// Revoking the lease deletes the candidacy.
+	return e.session.Close()
+}
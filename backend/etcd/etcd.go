@@ -2,37 +2,64 @@ package etcd
 
 import (
 	"context"
+	"errors"
 	"log"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/appootb/grc/backend"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/client/v3"
-	"go.etcd.io/etcd/client/v3/concurrency"
 )
 
+var errSlotTaken = errors.New("grc: slot taken")
+
+// Bounds of the backoff of renewing the leases.
+const (
+	minRetryInterval = time.Millisecond * 100
+	maxRetryInterval = backend.RetryTimeout
+)
+
+// alive is a key kept alive.
+type alive struct {
+	value  string
+	slot   string
+	lease  clientv3.LeaseID
+	cancel context.CancelFunc
+	done   chan struct{}
+	// Closes the Done channel of the slot.
+	lost func()
+}
+
 type Etcd struct {
-	ctx context.Context
+	ctx          context.Context
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+	mu           sync.Mutex
+	alive        map[string]*alive
+	readTimeout  time.Duration
+	writeTimeout time.Duration
 	*clientv3.Client
 }
 
-func NewProvider(ctx context.Context, endPoints []string, username, password string) (backend.Provider, error) {
-	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:            endPoints,
-		DialTimeout:          backend.DialTimeout,
-		DialKeepAliveTime:    backend.KeepAliveTime,
-		DialKeepAliveTimeout: backend.DialTimeout,
-		Username:             username,
-		Password:             password,
-	})
+func NewProvider(ctx context.Context, endPoints []string, username, password string, opts ...Option) (backend.Provider, error) {
+	o, err := newOptions(endPoints, username, password, opts...)
 	if err != nil {
 		return nil, err
 	}
-	return &Etcd{
-		ctx:    ctx,
-		Client: cli,
-	}, nil
+	cli, err := clientv3.New(o.Config)
+	if err != nil {
+		return nil, err
+	}
+	p := &Etcd{
+		alive:        make(map[string]*alive),
+		readTimeout:  o.readTimeout,
+		writeTimeout: o.writeTimeout,
+		Client:       cli,
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	return p, nil
 }
 
 // Type returns the provider type.
@@ -42,9 +69,13 @@ func (p *Etcd) Type() string {
 
 // Set value for the specified key with a specified ttl.
 func (p *Etcd) Set(key, value string, ttl time.Duration) error {
+	return p.set(p.ctx, key, value, ttl)
+}
+
+func (p *Etcd) set(ctx context.Context, key, value string, ttl time.Duration) error {
 	var options []clientv3.OpOption
 	if ttl > 0 {
-		leaseCtx, leaseCancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+		leaseCtx, leaseCancel := context.WithTimeout(ctx, p.writeTimeout)
 		defer leaseCancel()
 		lease, err := p.Grant(leaseCtx, int64(ttl.Seconds()))
 		if err != nil {
@@ -53,7 +84,7 @@ func (p *Etcd) Set(key, value string, ttl time.Duration) error {
 		options = append(options, clientv3.WithLease(lease.ID))
 	}
 
-	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	ctx, cancel := context.WithTimeout(ctx, p.writeTimeout)
 	defer cancel()
 	_, err := p.Client.Put(ctx, key, value, options...)
 	return err
@@ -61,12 +92,16 @@ func (p *Etcd) Set(key, value string, ttl time.Duration) error {
 
 // Get the value of the specified key or directory.
 func (p *Etcd) Get(key string, dir bool) (backend.KVPairs, error) {
+	return p.get(p.ctx, key, dir)
+}
+
+func (p *Etcd) get(ctx context.Context, key string, dir bool) (backend.KVPairs, error) {
 	var options []clientv3.OpOption
 	if dir {
 		options = append(options, clientv3.WithPrefix())
 	}
 
-	ctx, cancel := context.WithTimeout(p.ctx, backend.ReadTimeout)
+	ctx, cancel := context.WithTimeout(ctx, p.readTimeout)
 	defer cancel()
 	resp, err := p.Client.Get(ctx, key, options...)
 	if err != nil {
@@ -75,8 +110,9 @@ func (p *Etcd) Get(key string, dir bool) (backend.KVPairs, error) {
 	kvs := make(backend.KVPairs, 0, len(resp.Kvs))
 	for _, kv := range resp.Kvs {
 		kvs = append(kvs, &backend.KVPair{
-			Key:   string(kv.Key),
-			Value: string(kv.Value),
+			Key:      string(kv.Key),
+			Value:    string(kv.Value),
+			Revision: kv.ModRevision,
 		})
 	}
 	return kvs, nil
@@ -84,42 +120,57 @@ func (p *Etcd) Get(key string, dir bool) (backend.KVPairs, error) {
 
 // Incr invokes an atomic value increase for the specified key.
 func (p *Etcd) Incr(key string) (int64, error) {
-	session, err := concurrency.NewSession(p.Client)
-	if err != nil {
-		return 0, err
-	}
-	defer session.Close()
+	return p.incr(p.ctx, key)
+}
 
-	mutex := concurrency.NewMutex(session, key)
-	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout*2)
+// incr retries the optimistic transaction on the modification revision until it succeeds,
+// the current value is returned by the failed transaction, so a retry costs one round trip.
+func (p *Etcd) incr(ctx context.Context, key string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.writeTimeout*2)
 	defer cancel()
-	if err = mutex.Lock(ctx); err != nil {
-		return 0, err
-	}
-	defer mutex.Unlock(p.ctx)
 
-	num := int64(0)
-	kvs, err := p.Get(key, false)
+	resp, err := p.Client.Get(ctx, key)
 	if err != nil {
 		return 0, err
-	} else if len(kvs) > 0 {
-		num, _ = strconv.ParseInt(kvs[0].Value, 10, 64)
 	}
-	num++
-	if err = p.Set(key, strconv.FormatInt(num, 10), 0); err != nil {
-		return 0, err
+	kvs := resp.Kvs
+	for {
+		var (
+			num int64
+			cmp = clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+		)
+		if len(kvs) > 0 {
+			num, _ = strconv.ParseInt(string(kvs[0].Value), 10, 64)
+			cmp = clientv3.Compare(clientv3.ModRevision(key), "=", kvs[0].ModRevision)
+		}
+		num++
+		txnResp, err := p.Client.Txn(ctx).
+			If(cmp).
+			Then(clientv3.OpPut(key, strconv.FormatInt(num, 10))).
+			Else(clientv3.OpGet(key)).
+			Commit()
+		if err != nil {
+			return 0, err
+		}
+		if txnResp.Succeeded {
+			return num, nil
+		}
+		kvs = txnResp.Responses[0].GetResponseRange().Kvs
 	}
-	return num, nil
 }
 
 // Delete the specified key or directory.
 func (p *Etcd) Delete(key string, dir bool) error {
+	return p.delete(p.ctx, key, dir)
+}
+
+func (p *Etcd) delete(ctx context.Context, key string, dir bool) error {
 	var options []clientv3.OpOption
 	if dir {
 		options = append(options, clientv3.WithPrefix())
 	}
 
-	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	ctx, cancel := context.WithTimeout(ctx, p.writeTimeout)
 	defer cancel()
 	_, err := p.Client.Delete(ctx, key, options...)
 	return err
@@ -127,48 +178,59 @@ func (p *Etcd) Delete(key string, dir bool) error {
 
 // Watch for changes of the specified key or directory.
 func (p *Etcd) Watch(key string, dir bool) (backend.EventChan, error) {
-	revision, err := p.sync(key, dir, nil)
+	return p.watchContext(p.ctx, key, dir)
+}
+
+// watchContext watches until the ctx is done, then the channel is closed.
+func (p *Etcd) watchContext(ctx context.Context, key string, dir bool) (backend.EventChan, error) {
+	revision, err := p.sync(ctx, key, dir, nil)
 	if err != nil {
 		return nil, err
 	}
 	//
 	eventsChan := make(backend.EventChan, backend.DefaultChanLen)
 	//
-	go p.watch(key, dir, revision, eventsChan)
+	go p.watch(ctx, key, dir, revision, eventsChan)
 
 	return eventsChan, nil
 }
 
-func (p *Etcd) sync(key string, dir bool, eventsChan backend.EventChan) (int64, error) {
+func (p *Etcd) sync(ctx context.Context, key string, dir bool, eventsChan backend.EventChan) (int64, error) {
 	var options []clientv3.OpOption
 	if dir {
 		options = append(options, clientv3.WithPrefix())
 	}
 
-	ctx, cancel := context.WithTimeout(p.ctx, backend.ReadTimeout)
+	reqCtx, cancel := context.WithTimeout(ctx, p.readTimeout)
 	defer cancel()
 	//
-	resp, err := p.Client.Get(ctx, key, options...)
+	resp, err := p.Client.Get(reqCtx, key, options...)
 	if err != nil {
 		return 0, err
 	}
 	//
 	if eventsChan != nil {
 		for _, kv := range resp.Kvs {
-			eventsChan <- &backend.WatchEvent{
+			evt := &backend.WatchEvent{
 				Type: backend.Reset,
 				KVPair: backend.KVPair{
-					Key:   string(kv.Key),
-					Value: string(kv.Value),
+					Key:      string(kv.Key),
+					Value:    string(kv.Value),
+					Revision: kv.ModRevision,
 				},
 			}
+			if !backend.SendEvent(ctx, eventsChan, evt) {
+				return 0, ctx.Err()
+			}
 		}
 	}
 	//
 	return resp.Header.Revision, nil
 }
 
-func (p *Etcd) watch(key string, dir bool, revision int64, eventsChan backend.EventChan) {
+func (p *Etcd) watch(ctx context.Context, key string, dir bool, revision int64, eventsChan backend.EventChan) {
+	defer close(eventsChan)
+
 Retry:
 	options := []clientv3.OpOption{
 		clientv3.WithRev(revision),
@@ -178,12 +240,12 @@ Retry:
 		options = append(options, clientv3.WithPrefix())
 	}
 
-	ctx, cancel := context.WithCancel(p.ctx)
-	etcdChan := p.Client.Watch(ctx, key, options...)
+	watchCtx, cancel := context.WithCancel(ctx)
+	etcdChan := p.Client.Watch(watchCtx, key, options...)
 
 	for {
 		select {
-		case <-p.ctx.Done():
+		case <-ctx.Done():
 			cancel()
 			return
 
@@ -191,7 +253,8 @@ Retry:
 			if resp.CompactRevision > 0 {
 				time.Sleep(time.Second)
 				log.Println("grc: etcd revision compacted")
-				revision, _ = p.sync(key, dir, eventsChan)
+				cancel()
+				revision, _ = p.sync(ctx, key, dir, eventsChan)
 				goto Retry
 			} else if err := resp.Err(); err != nil {
 				cancel()
@@ -210,8 +273,9 @@ Retry:
 			for _, evt := range resp.Events {
 				wEvent := &backend.WatchEvent{
 					KVPair: backend.KVPair{
-						Key:   string(evt.Kv.Key),
-						Value: string(evt.Kv.Value),
+						Key:      string(evt.Kv.Key),
+						Value:    string(evt.Kv.Value),
+						Revision: evt.Kv.ModRevision,
 					},
 				}
 				if evt.Type == mvccpb.PUT {
@@ -219,7 +283,10 @@ Retry:
 				} else {
 					wEvent.Type = backend.Delete
 				}
-				eventsChan <- wEvent
+				if !backend.SendEvent(ctx, eventsChan, wEvent) {
+					cancel()
+					return
+				}
 			}
 			//
 			revision = resp.Header.GetRevision()
@@ -229,21 +296,131 @@ Retry:
 
 // KeepAlive sets value and updates the ttl for the specified key.
 func (p *Etcd) KeepAlive(key, value string, ttl time.Duration) error {
-	ch, err := p.keepAlive(key, value, ttl, false)
+	return p.keepAliveContext(p.ctx, key, value, ttl)
+}
+
+// keepAliveContext writes the key with the ctx, and keeps it alive until
+// the provider is closed or StopKeepAlive is called.
+func (p *Etcd) keepAliveContext(ctx context.Context, key, value string, ttl time.Duration) error {
+	// Replace the previous one.
+	if err := p.StopKeepAlive(key); err != nil {
+		return err
+	}
+	return p.startKeepAlive(ctx, key, &alive{
+		value: value,
+		done:  make(chan struct{}),
+	}, ttl)
+}
+
+// KeepAliveSlot claims the lowest free slot in [0, n) under the prefix, and writes the key
+// with the value of the slot, both are kept alive under the same lease until StopKeepAlive.
+func (p *Etcd) KeepAliveSlot(ctx context.Context, key, prefix string, n int64, ttl time.Duration,
+	value func(slot int64) string) (*backend.Slot, error) {
+	// Replace the previous one.
+	if err := p.StopKeepAlive(key); err != nil {
+		return nil, err
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, p.readTimeout)
+	resp, err := p.Client.Get(reqCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	cancel()
 	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		taken[string(kv.Key)] = true
+	}
+	for slot := int64(0); slot < n; slot++ {
+		slotKey := prefix + strconv.FormatInt(slot, 10)
+		if taken[slotKey] {
+			continue
+		}
+		s, lost := backend.NewSlot(slot)
+		err = p.startKeepAlive(ctx, key, &alive{
+			value: value(slot),
+			slot:  slotKey,
+			done:  make(chan struct{}),
+			lost:  lost,
+		}, ttl)
+		if err == errSlotTaken {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, backend.ErrSlotsExhausted
+}
+
+// startKeepAlive writes the key and keeps it alive until the provider is closed or StopKeepAlive is called.
+func (p *Etcd) startKeepAlive(ctx context.Context, key string, a *alive, ttl time.Duration) error {
+	aliveCtx, cancel := context.WithCancel(p.ctx)
+	a.cancel = cancel
+	ch, err := p.keepAlive(ctx, aliveCtx, key, a, ttl)
+	if err != nil {
+		cancel()
 		return err
 	}
+	p.mu.Lock()
+	p.alive[key] = a
+	p.mu.Unlock()
 
+	p.wg.Add(1)
 	go func() {
+		defer p.wg.Done()
+		defer close(a.done)
+		// Closes the Done channel of the slot once the goroutine exits.
+		if a.lost != nil {
+			defer a.lost()
+		}
+
+		var (
+			retry   <-chan time.Time
+			backoff = minRetryInterval
+		)
 		for {
 			select {
-			case m := <-ch:
-				// channel closed, retry
-				if m == nil {
-					ch, _ = p.keepAlive(key, value, ttl, true)
+			case m, ok := <-ch:
+				if ok && m != nil {
+					continue
+				}
+				// The lease expired or the channel closed, renew after the backoff.
+				ch, retry = nil, time.After(backoff)
+			case <-retry:
+				var err error
+				ch, err = p.keepAlive(aliveCtx, aliveCtx, key, a, ttl)
+				if err == errSlotTaken {
+					// Claimed by another key after the lease expired, the key is not kept alive any more.
+					log.Println("grc: etcd KeepAlive slot lost, ", key, a.slot)
+					p.mu.Lock()
+					if p.alive[key] == a {
+						delete(p.alive, key)
+					}
+					p.mu.Unlock()
+					cancel()
+					return
+				} else if err != nil {
+					if aliveCtx.Err() == nil {
+						log.Println("grc: etcd KeepAlive failed, ", err.Error())
+					}
+					if backoff *= 2; backoff > maxRetryInterval {
+						backoff = maxRetryInterval
+					}
+					retry = time.After(backoff)
+				} else {
+					backoff, retry = minRetryInterval, nil
+				}
+			case <-aliveCtx.Done():
+				ctx, cancel := context.WithTimeout(context.Background(), p.writeTimeout)
+				_, err := p.Client.Delete(ctx, key)
+				if err == nil {
+					p.mu.Lock()
+					lease := a.lease
+					p.mu.Unlock()
+					_, _ = p.Client.Revoke(ctx, lease)
 				}
-			case <-p.ctx.Done():
-				_, err = p.Client.Delete(context.TODO(), key)
+				cancel()
 				if err != nil {
 					log.Println("grc: etcd KeepAlive stopping, ", err.Error())
 				}
@@ -254,46 +431,90 @@ func (p *Etcd) KeepAlive(key, value string, ttl time.Duration) error {
 	return nil
 }
 
+// UpdateKeepAlive updates the value of the key kept alive, under the same lease.
+func (p *Etcd) UpdateKeepAlive(key, value string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a, ok := p.alive[key]
+	if !ok {
+		return backend.ErrNotKeptAlive
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, p.writeTimeout)
+	defer cancel()
+	if _, err := p.Client.Put(ctx, key, value, clientv3.WithLease(a.lease)); err != nil {
+		return err
+	}
+	a.value = value
+	return nil
+}
+
+// StopKeepAlive stops keeping the key alive and deletes it.
+func (p *Etcd) StopKeepAlive(key string) error {
+	p.mu.Lock()
+	a, ok := p.alive[key]
+	delete(p.alive, key)
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	a.cancel()
+	<-a.done
+	return nil
+}
+
 // Close the provider connection.
 func (p *Etcd) Close() error {
+	p.cancel()
+	// Wait for the keys kept alive to be deleted.
+	p.wg.Wait()
 	return p.Client.Close()
 }
 
-func (p *Etcd) keepAlive(key, value string, ttl time.Duration, withRetry bool) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
-Retry:
+// keepAlive writes the key with a new lease, the lease is kept alive until the aliveCtx is done.
+func (p *Etcd) keepAlive(ctx, aliveCtx context.Context, key string, a *alive, ttl time.Duration) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
 	// grant lease
-	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
-	lease, err := p.Grant(ctx, int64(ttl.Seconds()))
+	reqCtx, cancel := context.WithTimeout(ctx, p.writeTimeout)
+	lease, err := p.Grant(reqCtx, int64(ttl.Seconds()))
 	cancel()
 	if err != nil {
-		if withRetry {
-			time.Sleep(backend.RetryTimeout)
-			goto Retry
-		}
 		return nil, err
 	}
 
 	// put value with lease
-	ctx, cancel = context.WithTimeout(p.ctx, backend.WriteTimeout)
-	_, err = p.Client.Put(ctx, key, value, clientv3.WithLease(lease.ID))
+	p.mu.Lock()
+	a.lease = lease.ID
+	value := a.value
+	p.mu.Unlock()
+	reqCtx, cancel = context.WithTimeout(ctx, p.writeTimeout)
+	if a.slot == "" {
+		_, err = p.Client.Put(reqCtx, key, value, clientv3.WithLease(lease.ID))
+	} else {
+		err = p.putSlot(reqCtx, key, value, a.slot, lease.ID)
+	}
 	cancel()
 	if err != nil {
-		if withRetry {
-			time.Sleep(backend.RetryTimeout)
-			goto Retry
-		}
+		reqCtx, cancel = context.WithTimeout(context.Background(), p.writeTimeout)
+		_, _ = p.Client.Revoke(reqCtx, lease.ID)
+		cancel()
 		return nil, err
 	}
 
 	// keep alive to etcd
-	ch, err := p.Client.KeepAlive(p.ctx, lease.ID)
+	return p.Client.KeepAlive(aliveCtx, lease.ID)
+}
+
+// putSlot writes the key and the slot under the lease if the slot is free.
+func (p *Etcd) putSlot(ctx context.Context, key, value, slot string, lease clientv3.LeaseID) error {
+	resp, err := p.Client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(slot), "=", 0)).
+		Then(clientv3.OpPut(slot, key, clientv3.WithLease(lease)),
+			clientv3.OpPut(key, value, clientv3.WithLease(lease))).
+		Commit()
 	if err != nil {
-		if withRetry {
-			time.Sleep(backend.RetryTimeout)
-			goto Retry
-		}
-		return nil, err
+		return err
 	}
-
-	return ch, nil
+	if !resp.Succeeded {
+		return errSlotTaken
+	}
+	return nil
 }
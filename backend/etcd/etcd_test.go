@@ -0,0 +1,498 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"github.com/appootb/grc/backend/backendtest"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+var endpoint string
+
+func freeURL() url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "grc")
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	cfg.LCUrls = []url.URL{freeURL()}
+	cfg.ACUrls = cfg.LCUrls
+	cfg.LPUrls = []url.URL{freeURL()}
+	cfg.APUrls = cfg.LPUrls
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(time.Second * 10):
+		log.Fatal("etcd server start timeout")
+	}
+	endpoint = cfg.ACUrls[0].Host
+
+	code := m.Run()
+	server.Close()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newTestProvider(tb testing.TB) *Etcd {
+	p, err := NewProvider(context.Background(), []string{endpoint}, "", "")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = p.Close()
+	})
+	return p.(*Etcd)
+}
+
+func TestEtcd(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Provider {
+		p := newTestProvider(t)
+		// The server is shared by the tests.
+		if err := p.Delete("/backendtest/", true); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	})
+}
+
+func TestEtcd_Txn(t *testing.T) {
+	p := newTestProvider(t)
+	ctx := context.Background()
+
+	ok, err := p.CreateIfNotExists(ctx, "/test/txn/A", "a")
+	if err != nil || !ok {
+		t.Fatal("actual:", ok, err)
+	}
+	ok, err = p.CreateIfNotExists(ctx, "/test/txn/A", "b")
+	if err != nil || ok {
+		t.Fatal("actual:", ok, err)
+	}
+	kvs, err := p.Get("/test/txn/A", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Edited by another operator.
+	if err = p.Set("/test/txn/A", "c", 0); err != nil {
+		t.Fatal(err)
+	}
+	ok, err = p.CompareAndSet(ctx, "/test/txn/A", "d", kvs[0].Revision)
+	if err != nil || ok {
+		t.Fatal("actual:", ok, err)
+	}
+	kvs, _ = p.Get("/test/txn/A", false)
+	ok, err = p.Txn(ctx, []backend.Compare{
+		backend.CompareRevision("/test/txn/A", kvs[0].Revision),
+		backend.CompareAbsent("/test/txn/B"),
+	}, []backend.TxnOp{
+		backend.OpDelete("/test/txn/A"),
+		backend.OpPut("/test/txn/B", "b"),
+	})
+	if err != nil || !ok {
+		t.Fatal("actual:", ok, err)
+	}
+	kvs, _ = p.Get("/test/txn/", true)
+	if len(kvs) != 1 || kvs[0].Key != "/test/txn/B" {
+		t.Fatal("actual:", kvs)
+	}
+	// Bounded by the ctx.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = p.CreateIfNotExists(canceled, "/test/txn/C", "c"); err != context.Canceled {
+		t.Fatal("actual:", err)
+	}
+}
+
+func TestEtcd_WatchContext(t *testing.T) {
+	p := newTestProvider(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := p.Context().Watch(ctx, "/test/watch/", backend.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Set("/test/watch/A", "a", 0); err != nil {
+		t.Fatal(err)
+	}
+	evt := backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Put || evt.Key != "/test/watch/A" || evt.Value != "a" {
+		t.Fatal("actual:", evt)
+	}
+	cancel()
+	for evt = range ch {
+		t.Fatal("actual:", evt)
+	}
+}
+
+func TestEtcd_KeepAliveClose(t *testing.T) {
+	p := newTestProvider(t)
+	observer := newTestProvider(t)
+
+	if err := p.KeepAlive("/test/service/svc/node1", "n1", time.Second*3); err != nil {
+		t.Fatal(err)
+	}
+	kvs, err := observer.Get("/test/service/svc/node1", false)
+	if err != nil || len(kvs) != 1 {
+		t.Fatal("actual:", kvs, err)
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Deleted before the lease expires.
+	kvs, err = observer.Get("/test/service/svc/node1", false)
+	if err != nil || len(kvs) != 0 {
+		t.Fatal("actual:", kvs, err)
+	}
+}
+
+func TestEtcd_UpdateKeepAlive(t *testing.T) {
+	p := newTestProvider(t)
+
+	if err := p.KeepAlive("/test/service/svc/node2", "n1", time.Second*3); err != nil {
+		t.Fatal(err)
+	}
+	kvs, err := p.Get("/test/service/svc/node2", false)
+	if err != nil || len(kvs) != 1 {
+		t.Fatal("actual:", kvs, err)
+	}
+	if err = p.UpdateKeepAlive("/test/service/svc/node2", "n2"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := p.Client.Get(context.Background(), "/test/service/svc/node2")
+	if err != nil || len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != "n2" || resp.Kvs[0].Lease == 0 {
+		t.Fatal("actual:", resp, err)
+	}
+	if err = p.StopKeepAlive("/test/service/svc/node2"); err != nil {
+		t.Fatal(err)
+	}
+	kvs, err = p.Get("/test/service/svc/node2", false)
+	if err != nil || len(kvs) != 0 {
+		t.Fatal("actual:", kvs, err)
+	}
+	if err = p.UpdateKeepAlive("/test/service/svc/node2", "n3"); err != backend.ErrNotKeptAlive {
+		t.Fatal("actual:", err)
+	}
+}
+
+func TestEtcd_KeepAliveSlot(t *testing.T) {
+	p := newTestProvider(t)
+	ctx := context.Background()
+	value := func(slot int64) string {
+		return strconv.FormatInt(slot, 10)
+	}
+
+	for i, key := range []string{"/test/slot/svc/node1", "/test/slot/svc/node2"} {
+		slot, err := p.KeepAliveSlot(ctx, key, "/test/slot/ids/", 2, time.Second*3, value)
+		if err != nil || slot.ID != int64(i) {
+			t.Fatal("actual:", slot, err)
+		}
+	}
+	if _, err := p.KeepAliveSlot(ctx, "/test/slot/svc/node3", "/test/slot/ids/", 2, time.Second*3, value); err != backend.ErrSlotsExhausted {
+		t.Fatal("actual:", err)
+	}
+	if err := p.StopKeepAlive("/test/slot/svc/node1"); err != nil {
+		t.Fatal(err)
+	}
+	slot, err := p.KeepAliveSlot(ctx, "/test/slot/svc/node3", "/test/slot/ids/", 2, time.Second*3, value)
+	if err != nil || slot.ID != 0 {
+		t.Fatal("actual:", slot, err)
+	}
+	kvs, err := p.Get("/test/slot/svc/node3", false)
+	if err != nil || len(kvs) != 1 || kvs[0].Value != "0" {
+		t.Fatal("actual:", kvs, err)
+	}
+
+	// Released when the lease expires.
+	q, err := NewProvider(context.Background(), []string{endpoint}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashed := q.(*Etcd)
+	if _, err = crashed.KeepAliveSlot(ctx, "/test/slot/svc/node4", "/test/slot/ids/", 3, time.Second, value); err != nil {
+		t.Fatal(err)
+	}
+	_ = crashed.Client.Close()
+	deadline := time.Now().Add(time.Second * 10)
+	for {
+		slot, err = p.KeepAliveSlot(ctx, "/test/slot/svc/node5", "/test/slot/ids/", 3, time.Second*3, value)
+		if err == nil {
+			break
+		}
+		if err != backend.ErrSlotsExhausted || time.Now().After(deadline) {
+			t.Fatal("actual:", err)
+		}
+		time.Sleep(time.Millisecond * 200)
+	}
+	if slot.ID != 2 {
+		t.Fatal("actual:", slot)
+	}
+	crashed.cancel()
+}
+
+// The slot taken by another key after the lease expired is lost, not renewed.
+func TestEtcd_KeepAliveSlotLost(t *testing.T) {
+	p := newTestProvider(t)
+	ctx := context.Background()
+	value := func(slot int64) string {
+		return strconv.FormatInt(slot, 10)
+	}
+
+	slot, err := p.KeepAliveSlot(ctx, "/test/lost/svc/node1", "/test/lost/ids/", 1, time.Second*3, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The lease expires, and the slot is stolen before renewed.
+	p.mu.Lock()
+	lease := p.alive["/test/lost/svc/node1"].lease
+	p.mu.Unlock()
+	if _, err = p.Client.Revoke(ctx, lease); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Set("/test/lost/ids/0", "/test/lost/svc/node2", 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-slot.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("slot lost not notified")
+	}
+	kvs, err := p.Get("/test/lost/ids/0", false)
+	if err != nil || len(kvs) != 1 || kvs[0].Value != "/test/lost/svc/node2" {
+		t.Fatal("actual:", kvs, err)
+	}
+	// Not kept alive any more.
+	p.mu.Lock()
+	_, ok := p.alive["/test/lost/svc/node1"]
+	p.mu.Unlock()
+	if ok {
+		t.Fatal("slot lost still kept alive")
+	}
+	if err = p.UpdateKeepAlive("/test/lost/svc/node1", "v"); err != backend.ErrNotKeptAlive {
+		t.Fatal("actual:", err)
+	}
+
+	// Renewed after the lease expired, if the slot is not taken.
+	slot, err = p.KeepAliveSlot(ctx, "/test/lost/svc/node3", "/test/lost/ids/", 2, time.Second*3, value)
+	if err != nil || slot.ID != 1 {
+		t.Fatal("actual:", slot, err)
+	}
+	p.mu.Lock()
+	lease = p.alive["/test/lost/svc/node3"].lease
+	p.mu.Unlock()
+	if _, err = p.Client.Revoke(ctx, lease); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		kvs, err = p.Get("/test/lost/ids/1", false)
+		if err == nil && len(kvs) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("actual:", kvs, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	select {
+	case <-slot.Done():
+		t.Fatal("slot renewed lost")
+	default:
+	}
+	if err = p.StopKeepAlive("/test/lost/svc/node3"); err != nil {
+		t.Fatal(err)
+	}
+	<-slot.Done()
+}
+
+func TestEtcd_Election(t *testing.T) {
+	p := newTestProvider(t)
+	ctx := context.Background()
+
+	if _, err := p.Leader(ctx, "/test/election/svc"); err != backend.ErrNoLeader {
+		t.Fatal("actual:", err)
+	}
+	e1, err := p.NewElection(ctx, "/test/election/svc", time.Second*3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := p.NewElection(ctx, "/test/election/svc", time.Second*3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e2.Close()
+	observeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	observe := e2.Observe(observeCtx)
+
+	if _, err = e1.Campaign(ctx, "node1"); err != nil {
+		t.Fatal(err)
+	}
+	elected := make(chan error, 1)
+	go func() {
+		_, err := e2.Campaign(ctx, "node2")
+		elected <- err
+	}()
+	if leader, err := p.Leader(ctx, "/test/election/svc"); err != nil || leader != "node1" {
+		t.Fatal("actual:", leader, err)
+	}
+	waitLeader := func(expect string) {
+		select {
+		case leader := <-observe:
+			if leader != expect {
+				t.Fatal("actual:", leader)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatal("observe timeout")
+		}
+	}
+	waitLeader("node1")
+
+	// Closing the session of the leader elects the next.
+	if err = e1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-elected:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("campaign timeout")
+	}
+	waitLeader("node2")
+	if err = e2.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Leader(ctx, "/test/election/svc"); err != backend.ErrNoLeader {
+		t.Fatal("actual:", err)
+	}
+}
+
+func TestEtcd_Acquire(t *testing.T) {
+	p := newTestProvider(t)
+	ctx := context.Background()
+
+	pm1, err := p.Acquire(ctx, "/test/lock/sem", 2, time.Second*3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm2, err := p.Acquire(ctx, "/test/lock/sem", 2, time.Second*3)
+	if err != nil || pm2.Token() <= pm1.Token() {
+		t.Fatal("actual:", pm1.Token(), pm2.Token(), err)
+	}
+	// Canceled while waiting.
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*200)
+	defer cancel()
+	if _, err = p.Acquire(timeoutCtx, "/test/lock/sem", 2, time.Second*3); err != context.DeadlineExceeded {
+		t.Fatal("actual:", err)
+	}
+	acquired := make(chan int64, 1)
+	go func() {
+		pm3, err := p.Acquire(ctx, "/test/lock/sem", 2, time.Second*3)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		acquired <- pm3.Token()
+		_ = pm3.Release(ctx)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired more than 2 permits")
+	case <-time.After(time.Millisecond * 200):
+	}
+	if err = pm1.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-pm1.Done():
+	default:
+		t.Fatal("permit not done")
+	}
+	select {
+	case token := <-acquired:
+		if token <= pm2.Token() {
+			t.Fatal("actual:", token)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("acquire timeout")
+	}
+	if err = pm2.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkEtcd_Incr(b *testing.B) {
+	p := newTestProvider(b)
+	key := fmt.Sprintf("/bench/incr/%d", time.Now().UnixNano())
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := p.Incr(key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkEtcd_IncrMutex measures the former mutex session implementation for comparison.
+func BenchmarkEtcd_IncrMutex(b *testing.B) {
+	p := newTestProvider(b)
+	key := fmt.Sprintf("/bench/mutex/%d", time.Now().UnixNano())
+	incr := func() error {
+		session, err := concurrency.NewSession(p.Client)
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+		mutex := concurrency.NewMutex(session, key)
+		if err = mutex.Lock(p.ctx); err != nil {
+			return err
+		}
+		defer mutex.Unlock(p.ctx)
+		kvs, err := p.Get(key, false)
+		if err != nil {
+			return err
+		}
+		num := int64(0)
+		if len(kvs) > 0 {
+			num, _ = strconv.ParseInt(kvs[0].Value, 10, 64)
+		}
+		return p.Set(key, strconv.FormatInt(num+1, 10), 0)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := incr(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// Acquire blocks until one of the n permits of the prefix is acquired,
+// the holders are the n keys of the lowest create revisions under the prefix,
+// the create revision is the fencing token.
+func (p *Etcd) Acquire(ctx context.Context, prefix string, n int, ttl time.Duration) (backend.Permit, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, p.writeTimeout)
+	lease, err := p.Client.Grant(reqCtx, int64(ttl.Seconds()))
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	session, err := concurrency.NewSession(p.Client, concurrency.WithLease(lease.ID),
+		concurrency.WithContext(p.ctx))
+	if err != nil {
+		return nil, err
+	}
+	pm := &permit{
+		p:       p,
+		session: session,
+		key:     fmt.Sprintf("%s/%x", prefix, lease.ID),
+	}
+	reqCtx, cancel = context.WithTimeout(ctx, p.writeTimeout)
+	resp, err := p.Client.Put(reqCtx, pm.key, "", clientv3.WithLease(lease.ID))
+	cancel()
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	pm.token = resp.Header.Revision
+
+	if err = pm.wait(ctx, prefix+"/", n); err != nil {
+		_ = pm.Release(context.Background())
+		return nil, err
+	}
+	return pm, nil
+}
+
+type permit struct {
+	p       *Etcd
+	session *concurrency.Session
+	key     string
+	token   int64
+}
+
+// wait until less than n keys are created before the permit.
+func (pm *permit) wait(ctx context.Context, prefix string, n int) error {
+	for {
+		reqCtx, cancel := context.WithTimeout(ctx, pm.p.readTimeout)
+		resp, err := pm.p.Client.Get(reqCtx, prefix, clientv3.WithPrefix(),
+			clientv3.WithMaxCreateRev(pm.token-1), clientv3.WithKeysOnly())
+		cancel()
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) < n {
+			return nil
+		}
+		// Wait for the keys deleted.
+		watchCtx, cancel := context.WithCancel(ctx)
+		ch := pm.p.Client.Watch(watchCtx, prefix, clientv3.WithPrefix(), clientv3.WithFilterPut(),
+			clientv3.WithRev(resp.Header.Revision+1))
+		select {
+		case <-ch:
+			cancel()
+		case <-pm.session.Done():
+			cancel()
+			return backend.ErrPermitLost
+		case <-ctx.Done():
+			cancel()
+			return ctx.Err()
+		}
+	}
+}
+
+func (pm *permit) Token() int64 {
+	return pm.token
+}
+
+func (pm *permit) Done() <-chan struct{} {
+	return pm.session.Done()
+}
+
+func (pm *permit) Release(ctx context.Context) error {
+	reqCtx, cancel := context.WithTimeout(ctx, pm.p.writeTimeout)
+	_, err := pm.p.Client.Delete(reqCtx, pm.key)
+	cancel()
+	// Revoking the lease deletes the key anyway.
+	if closeErr := pm.session.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package etcd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+)
+
+// Option sets options such as TLS, timeouts, etc.
+type Option func(*options)
+
+type options struct {
+	clientv3.Config
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	certFile string
+	keyFile  string
+	caFile   string
+}
+
+func newOptions(endPoints []string, username, password string, opts ...Option) (*options, error) {
+	o := &options{
+		Config: clientv3.Config{
+			Endpoints:            endPoints,
+			DialTimeout:          backend.DialTimeout,
+			DialKeepAliveTime:    backend.KeepAliveTime,
+			DialKeepAliveTimeout: backend.DialTimeout,
+			Username:             username,
+			Password:             password,
+		},
+		readTimeout:  backend.ReadTimeout,
+		writeTimeout: backend.WriteTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.certFile == "" && o.caFile == "" {
+		return o, nil
+	}
+	// Load the TLS files.
+	if o.TLS == nil {
+		o.TLS = &tls.Config{}
+	}
+	if o.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		o.TLS.Certificates = append(o.TLS.Certificates, cert)
+	}
+	if o.caFile != "" {
+		pem, err := ioutil.ReadFile(o.caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("grc: invalid etcd CA file %s", o.caFile)
+		}
+		o.TLS.RootCAs = pool
+	}
+	return o, nil
+}
+
+// WithTLS sets the TLS configuration of the client.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.TLS = cfg
+	}
+}
+
+// WithTLSFiles sets the client certificate, key and the CA certificate files,
+// certFile and caFile are optional.
+func WithTLSFiles(certFile, keyFile, caFile string) Option {
+	return func(o *options) {
+		o.certFile = certFile
+		o.keyFile = keyFile
+		o.caFile = caFile
+	}
+}
+
+// WithToken authenticates the requests with a token issued by etcd, such as a JWT token.
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.DialOptions = append(o.DialOptions, grpc.WithPerRPCCredentials(tokenCredential(token)))
+	}
+}
+
+// WithAutoSyncInterval updates the endpoints with the cluster members periodically.
+func WithAutoSyncInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.AutoSyncInterval = interval
+	}
+}
+
+// WithDialTimeout sets the timeout for establishing a connection.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.DialTimeout = timeout
+		o.DialKeepAliveTimeout = timeout
+	}
+}
+
+// WithReadTimeout sets the timeout of the read requests.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.readTimeout = timeout
+	}
+}
+
+// WithWriteTimeout sets the timeout of the write requests.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.writeTimeout = timeout
+	}
+}
+
+// WithMaxCallMsgSize sets the max message size in bytes of the requests and responses.
+func WithMaxCallMsgSize(sendSize, recvSize int) Option {
+	return func(o *options) {
+		o.MaxCallSendMsgSize = sendSize
+		o.MaxCallRecvMsgSize = recvSize
+	}
+}
+
+type tokenCredential string
+
+func (t tokenCredential) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{
+		rpctypes.TokenFieldNameGRPC: string(t),
+	}, nil
+}
+
+func (t tokenCredential) RequireTransportSecurity() bool {
+	return false
+}
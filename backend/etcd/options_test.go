@@ -0,0 +1,49 @@
+package etcd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc/backend"
+)
+
+func TestOptions(t *testing.T) {
+	o, err := newOptions([]string{"127.0.0.1:2379"}, "user", "pass",
+		WithReadTimeout(time.Second),
+		WithDialTimeout(time.Second*5),
+		WithAutoSyncInterval(time.Minute),
+		WithMaxCallMsgSize(1024, 2048))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.readTimeout != time.Second || o.writeTimeout != backend.WriteTimeout ||
+		o.DialTimeout != time.Second*5 || o.AutoSyncInterval != time.Minute ||
+		o.MaxCallSendMsgSize != 1024 || o.MaxCallRecvMsgSize != 2048 || o.TLS != nil {
+		t.Fatal("actual:", o)
+	}
+}
+
+func TestOptions_TLSFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := filepath.Join(dir, "ca.pem")
+	if _, err = newOptions(nil, "", "", WithTLSFiles("", "", ca)); err == nil {
+		t.Fatal("missing CA file accepted")
+	}
+	if err = ioutil.WriteFile(ca, []byte("invalid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newOptions(nil, "", "", WithTLSFiles("", "", ca)); err == nil {
+		t.Fatal("invalid CA file accepted")
+	}
+	if _, err = newOptions(nil, "", "", WithTLSFiles(ca, ca, "")); err == nil {
+		t.Fatal("invalid certificate accepted")
+	}
+}
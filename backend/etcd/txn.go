@@ -0,0 +1,49 @@
+package etcd
+
+import (
+	"context"
+
+	"github.com/appootb/grc/backend"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// CompareAndSet sets the value if the modification revision of the key equals the revision,
+// the key must not exist if the revision is 0.
+func (p *Etcd) CompareAndSet(ctx context.Context, key, value string, revision int64) (bool, error) {
+	return p.Txn(ctx, []backend.Compare{backend.CompareRevision(key, revision)},
+		[]backend.TxnOp{backend.OpPut(key, value)})
+}
+
+// CreateIfNotExists sets the value if the key does not exist.
+func (p *Etcd) CreateIfNotExists(ctx context.Context, key, value string) (bool, error) {
+	return p.Txn(ctx, []backend.Compare{backend.CompareAbsent(key)},
+		[]backend.TxnOp{backend.OpPut(key, value)})
+}
+
+// Txn applies the operations atomically if all the comparisons succeed.
+func (p *Etcd) Txn(ctx context.Context, cmps []backend.Compare, ops []backend.TxnOp) (bool, error) {
+	conditions := make([]clientv3.Cmp, 0, len(cmps))
+	for _, cmp := range cmps {
+		if cmp.Revision == 0 {
+			conditions = append(conditions, clientv3.Compare(clientv3.CreateRevision(cmp.Key), "=", 0))
+		} else {
+			conditions = append(conditions, clientv3.Compare(clientv3.ModRevision(cmp.Key), "=", cmp.Revision))
+		}
+	}
+	operations := make([]clientv3.Op, 0, len(ops))
+	for _, op := range ops {
+		if op.Type == backend.Delete {
+			operations = append(operations, clientv3.OpDelete(op.Key))
+		} else {
+			operations = append(operations, clientv3.OpPut(op.Key, op.Value))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.writeTimeout)
+	defer cancel()
+	resp, err := p.Client.Txn(ctx).If(conditions...).Then(operations...).Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
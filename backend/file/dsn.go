@@ -0,0 +1,22 @@
+package file
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/appootb/grc/backend"
+)
+
+func init() {
+	backend.Register(backend.File, Open)
+}
+
+// Open creates a file provider from the DSN, the path is the root directory,
+// file:///var/lib/grc?base=/base
+func Open(ctx context.Context, dsn *url.URL) (backend.Provider, string, error) {
+	provider, err := NewProvider(ctx, dsn.Path)
+	if err != nil {
+		return nil, "", err
+	}
+	return provider, dsn.Query().Get("base"), nil
+}
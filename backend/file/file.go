@@ -0,0 +1,396 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appootb/grc/backend"
+)
+
+const (
+	// Hidden files are ignored as keys.
+	hiddenPrefix = "."
+	expireSuffix = ".expire"
+	lockSuffix   = ".lock"
+)
+
+var (
+	// Interval of the watch polling and the ttl sweeping.
+	PollInterval = time.Second
+)
+
+// File stores every key as a file under the root directory,
+// keys with ttl get an expiry sidecar file next to them.
+type File struct {
+	root     string
+	interval time.Duration
+	watches  backend.Watches
+	alive    backend.KeepAlives
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewProvider(ctx context.Context, root string) (backend.Provider, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	p := &File{
+		root:     root,
+		interval: PollInterval,
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	go p.checkTTL()
+	return p, nil
+}
+
+// Type returns the provider type.
+func (p *File) Type() string {
+	return backend.File
+}
+
+// Set value for the specified key with a specified ttl.
+func (p *File) Set(key, value string, ttl time.Duration) error {
+	name := p.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		expire := time.Now().Add(ttl).UnixNano()
+		if err := writeFile(sidecar(name, expireSuffix), strconv.FormatInt(expire, 10)); err != nil {
+			return err
+		}
+		return writeFile(name, value)
+	}
+	if err := writeFile(name, value); err != nil {
+		return err
+	}
+	err := os.Remove(sidecar(name, expireSuffix))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Get the value of the specified key or directory.
+func (p *File) Get(key string, dir bool) (backend.KVPairs, error) {
+	if !dir {
+		value, ok, err := p.read(p.path(key))
+		if err != nil || !ok {
+			return backend.KVPairs{}, err
+		}
+		return backend.KVPairs{
+			{
+				Key:   key,
+				Value: value,
+			},
+		}, nil
+	}
+	//
+	var kvs backend.KVPairs
+	err := p.walk(key, func(k, name string) error {
+		value, ok, err := p.read(name)
+		if err != nil || !ok {
+			return err
+		}
+		kvs = append(kvs, &backend.KVPair{
+			Key:   k,
+			Value: value,
+		})
+		return nil
+	})
+	return kvs, err
+}
+
+// Incr invokes an atomic value increase for the specified key.
+func (p *File) Incr(key string) (int64, error) {
+	name := p.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return 0, err
+	}
+	unlock, err := p.lock(name)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	value, _, err := p.read(name)
+	if err != nil {
+		return 0, err
+	}
+	num, _ := strconv.ParseInt(value, 10, 64)
+	num++
+	if err = writeFile(name, strconv.FormatInt(num, 10)); err != nil {
+		return 0, err
+	}
+	return num, nil
+}
+
+// Delete the specified key or directory.
+func (p *File) Delete(key string, dir bool) error {
+	if !dir {
+		return p.remove(p.path(key))
+	}
+	//
+	return p.walk(key, func(_, name string) error {
+		return p.remove(name)
+	})
+}
+
+// Watch for changes of the specified key or directory.
+func (p *File) Watch(key string, dir bool) (backend.EventChan, error) {
+	kvs, err := p.Get(key, dir)
+	if err != nil {
+		return nil, err
+	}
+	//
+	ctx, eventsChan := p.watches.Add(p.ctx)
+	//
+	go p.watch(ctx, key, dir, snapshot(kvs), eventsChan)
+
+	return eventsChan, nil
+}
+
+// Unwatch stops the watch of the channel returned by Watch.
+func (p *File) Unwatch(ch backend.EventChan) error {
+	p.watches.Remove(ch)
+	return nil
+}
+
+func (p *File) watch(ctx context.Context, key string, dir bool, last map[string]string, eventsChan backend.EventChan) {
+	defer p.watches.Remove(eventsChan)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			kvs, err := p.Get(key, dir)
+			if err != nil {
+				log.Println("grc: file watch error, ", err.Error())
+				continue
+			}
+			current := snapshot(kvs)
+			for k, v := range current {
+				if prev, ok := last[k]; ok && prev == v {
+					continue
+				}
+				if !backend.SendEvent(ctx, eventsChan, newEvent(backend.Put, k, v)) {
+					return
+				}
+			}
+			for k, v := range last {
+				if _, ok := current[k]; ok {
+					continue
+				}
+				if !backend.SendEvent(ctx, eventsChan, newEvent(backend.Delete, k, v)) {
+					return
+				}
+			}
+			last = current
+		}
+	}
+}
+
+// KeepAlive sets value and updates the ttl for the specified key.
+func (p *File) KeepAlive(key, value string, ttl time.Duration) error {
+	return p.alive.Start(p.ctx, key, value, ttl, p.Set, func(key string) error {
+		return p.remove(p.path(key))
+	})
+}
+
+// UpdateKeepAlive updates the value of the key kept alive, the ttl is kept.
+func (p *File) UpdateKeepAlive(key, value string) error {
+	return p.alive.Update(key, value)
+}
+
+// StopKeepAlive stops keeping the key alive and deletes it.
+func (p *File) StopKeepAlive(key string) error {
+	return p.alive.Stop(key)
+}
+
+// Close the provider connection.
+func (p *File) Close() error {
+	p.cancel()
+	// Wait for the keys kept alive to be deleted.
+	p.alive.Wait()
+	return nil
+}
+
+func (p *File) checkTTL() {
+	ticker := time.NewTicker(p.interval)
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			ticker.Stop()
+			return
+		case <-ticker.C:
+			err := filepath.Walk(p.root, func(name string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() || !strings.HasSuffix(info.Name(), expireSuffix) {
+					return nil
+				}
+				key := filepath.Join(filepath.Dir(name),
+					strings.TrimSuffix(strings.TrimPrefix(info.Name(), hiddenPrefix), expireSuffix))
+				if p.expired(key) {
+					return p.remove(key)
+				}
+				return nil
+			})
+			if err != nil {
+				log.Println("grc: file checkTTL failed, ", err.Error())
+			}
+		}
+	}
+}
+
+// walk invokes fn for every key with the prefix.
+func (p *File) walk(prefix string, fn func(key, name string) error) error {
+	dir := p.path(prefix[:strings.LastIndex(prefix, "/")+1])
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if strings.HasPrefix(info.Name(), hiddenPrefix) {
+			if info.IsDir() && name != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		key := p.key(prefix, name)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		return fn(key, name)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (p *File) read(name string) (string, bool, error) {
+	if p.expired(name) {
+		return "", false, nil
+	}
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return string(b), true, nil
+}
+
+func (p *File) expired(name string) bool {
+	b, err := ioutil.ReadFile(sidecar(name, expireSuffix))
+	if err != nil {
+		return false
+	}
+	expire, _ := strconv.ParseInt(string(b), 10, 64)
+	return time.Now().UnixNano() > expire
+}
+
+func (p *File) remove(name string) error {
+	for _, n := range []string{name, sidecar(name, expireSuffix)} {
+		if err := os.Remove(n); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// lock the file across processes with an exclusive lock file.
+func (p *File) lock(name string) (func(), error) {
+	lockName := sidecar(name, lockSuffix)
+	deadline := time.Now().Add(backend.WriteTimeout)
+	for {
+		f, err := os.OpenFile(lockName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_ = f.Close()
+			return func() {
+				_ = os.Remove(lockName)
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		// Remove the stale lock left by a crashed process.
+		if info, err := os.Stat(lockName); err == nil && time.Since(info.ModTime()) > backend.WriteTimeout {
+			_ = os.Remove(lockName)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("grc: file lock %s timeout", name)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func (p *File) path(key string) string {
+	return filepath.Join(p.root, filepath.FromSlash(strings.TrimPrefix(key, "/")))
+}
+
+func (p *File) key(requested, name string) string {
+	rel, _ := filepath.Rel(p.root, name)
+	if strings.HasPrefix(requested, "/") {
+		return "/" + filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func sidecar(name, suffix string) string {
+	return filepath.Join(filepath.Dir(name), hiddenPrefix+filepath.Base(name)+suffix)
+}
+
+// writeFile replaces the file atomically.
+func writeFile(name, value string) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), hiddenPrefix+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(value); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+func snapshot(kvs backend.KVPairs) map[string]string {
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func newEvent(typ backend.EventType, key, value string) *backend.WatchEvent {
+	return &backend.WatchEvent{
+		Type: typ,
+		KVPair: backend.KVPair{
+			Key:   key,
+			Value: value,
+		},
+	}
+}
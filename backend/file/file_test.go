@@ -0,0 +1,96 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"github.com/appootb/grc/backend/backendtest"
+)
+
+func newTestProvider(t *testing.T) (string, backend.Provider) {
+	interval := PollInterval
+	PollInterval = time.Millisecond * 50
+	t.Cleanup(func() {
+		PollInterval = interval
+	})
+	root, err := ioutil.TempDir("", "grc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(root)
+	})
+	p, err := NewProvider(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+	return root, p
+}
+
+func TestFile(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Provider {
+		_, p := newTestProvider(t)
+		return p
+	})
+}
+
+func TestFile_WatchEdit(t *testing.T) {
+	root, p := newTestProvider(t)
+
+	if err := p.Set("/test/config/svc/A", "a", 0); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := p.Watch("/test/config/svc/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Edited by someone else.
+	err = ioutil.WriteFile(filepath.Join(root, "test", "config", "svc", "A"), []byte("b"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt := backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Put || evt.Key != "/test/config/svc/A" || evt.Value != "b" {
+		t.Fatal("actual:", evt)
+	}
+}
+
+func TestFile_TTL(t *testing.T) {
+	_, p := newTestProvider(t)
+
+	ch, err := p.Watch("/test/service/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.KeepAlive("/test/service/svc/node1", "n1", time.Millisecond*300); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Set("/test/service/svc/node2", "n2", time.Millisecond*300); err != nil {
+		t.Fatal(err)
+	}
+	events := map[string]backend.EventType{}
+	for len(events) < 2 {
+		evt := backendtest.WaitEvent(t, ch)
+		events[evt.Key] = evt.Type
+	}
+	evt := backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Delete || evt.Key != "/test/service/svc/node2" {
+		t.Fatal("actual:", evt)
+	}
+	time.Sleep(time.Millisecond * 500)
+	kvs, err := p.Get("/test/service/svc/node1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || kvs[0].Value != "n1" {
+		t.Fatal("actual:", kvs)
+	}
+}
@@ -0,0 +1,209 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotSupported is returned if the provider does not support the operation.
+	ErrNotSupported = errors.New("grc: operation not supported by the provider")
+	// ErrNotKeptAlive is returned if the key is not kept alive by the provider.
+	ErrNotKeptAlive = errors.New("grc: key not kept alive")
+	// ErrSlotsExhausted is returned if no slot is free.
+	ErrSlotsExhausted = errors.New("grc: slots exhausted")
+	// ErrInvalidTTL is returned if the ttl of the key kept alive is not positive.
+	ErrInvalidTTL = errors.New("grc: invalid keep alive ttl")
+)
+
+// MinKeepAliveInterval is the minimum interval of refreshing the keys kept alive.
+const MinKeepAliveInterval = time.Millisecond * 100
+
+// KeepAliveInterval returns the interval of refreshing the key kept alive with the ttl,
+// a third of the ttl and at least MinKeepAliveInterval.
+func KeepAliveInterval(ttl time.Duration) (time.Duration, error) {
+	if ttl <= 0 {
+		return 0, ErrInvalidTTL
+	}
+	if interval := ttl / 3; interval > MinKeepAliveInterval {
+		return interval, nil
+	}
+	return MinKeepAliveInterval, nil
+}
+
+// KeepAliveUpdater is implemented by the providers which can update the keys kept alive.
+type KeepAliveUpdater interface {
+	// UpdateKeepAlive updates the value of the key kept alive, the ttl is kept.
+	UpdateKeepAlive(key, value string) error
+
+	// StopKeepAlive stops keeping the key alive and deletes it.
+	StopKeepAlive(key string) error
+}
+
+// SlotAllocator is implemented by the providers which can claim slots bound to the keys kept alive.
+type SlotAllocator interface {
+	// KeepAliveSlot claims the lowest free slot in [0, n) under the prefix, and writes the key
+	// with the value of the slot, both are kept alive under the same lease until StopKeepAlive,
+	// the slot is released when the lease expires. ErrSlotsExhausted if no slot is free.
+	KeepAliveSlot(ctx context.Context, key, prefix string, n int64, ttl time.Duration,
+		value func(slot int64) string) (*Slot, error)
+}
+
+// Slot is a slot claimed by KeepAliveSlot.
+type Slot struct {
+	ID   int64
+	done chan struct{}
+}
+
+// NewSlot returns the slot, and the function closing its Done channel, for the providers.
+func NewSlot(id int64) (*Slot, func()) {
+	s := &Slot{
+		ID:   id,
+		done: make(chan struct{}),
+	}
+	var once sync.Once
+	return s, func() {
+		once.Do(func() {
+			close(s.done)
+		})
+	}
+}
+
+// Done returns a channel which is closed when the slot is not held any more,
+// released by StopKeepAlive, or taken by another key after the lease expired.
+func (s *Slot) Done() <-chan struct{} {
+	return s.done
+}
+
+// KeepAlives keeps the keys alive by refreshing them every KeepAliveInterval,
+// for the providers without leases.
+type KeepAlives struct {
+	// Refresh extends the ttl of the key without rewriting it, and returns false if the key
+	// is missing, which is written again. The keys are rewritten if Refresh is nil.
+	Refresh func(key string, ttl time.Duration) (bool, error)
+
+	mu   sync.Mutex
+	keys map[string]*keptAlive
+	wg   sync.WaitGroup
+}
+
+type keptAlive struct {
+	// Serializes the writes of the key.
+	mu    sync.Mutex
+	value string
+	ttl   time.Duration
+	set   func(key, value string, ttl time.Duration) error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Start writes the key with set and rewrites it until the ctx is done or Stop is called,
+// then the key is deleted with del. The key kept alive before is stopped first.
+func (ka *KeepAlives) Start(ctx context.Context, key, value string, ttl time.Duration,
+	set func(key, value string, ttl time.Duration) error, del func(key string) error) error {
+	interval, err := KeepAliveInterval(ttl)
+	if err != nil {
+		return err
+	}
+	if err = ka.Stop(key); err != nil {
+		return err
+	}
+	if err = set(key, value, ttl); err != nil {
+		return err
+	}
+	a := &keptAlive{
+		value: value,
+		ttl:   ttl,
+		set:   set,
+		done:  make(chan struct{}),
+	}
+	a.ctx, a.cancel = context.WithCancel(ctx)
+	ka.mu.Lock()
+	if ka.keys == nil {
+		ka.keys = make(map[string]*keptAlive)
+	}
+	ka.keys[key] = a
+	ka.mu.Unlock()
+
+	ka.wg.Add(1)
+	go func() {
+		defer ka.wg.Done()
+		defer close(a.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				a.mu.Lock()
+				err := ka.refresh(key, a)
+				a.mu.Unlock()
+				if err != nil && a.ctx.Err() == nil {
+					log.Println("grc: KeepAlive failed, ", key, err.Error())
+				}
+			case <-a.ctx.Done():
+				a.mu.Lock()
+				err := del(key)
+				a.mu.Unlock()
+				if err != nil {
+					log.Println("grc: KeepAlive stopping, ", key, err.Error())
+				}
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (ka *KeepAlives) refresh(key string, a *keptAlive) error {
+	if ka.Refresh != nil {
+		if ok, err := ka.Refresh(key, a.ttl); err != nil || ok {
+			return err
+		}
+	}
+	return a.set(key, a.value, a.ttl)
+}
+
+// Update writes the new value of the key kept alive, the ttl is kept.
+func (ka *KeepAlives) Update(key, value string) error {
+	ka.mu.Lock()
+	a, ok := ka.keys[key]
+	ka.mu.Unlock()
+	if !ok {
+		return ErrNotKeptAlive
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ctx.Err() != nil {
+		return ErrNotKeptAlive
+	}
+	if err := a.set(key, value, a.ttl); err != nil {
+		return err
+	}
+	a.value = value
+	return nil
+}
+
+// Stop stops keeping the key alive and waits until it is deleted.
+func (ka *KeepAlives) Stop(key string) error {
+	ka.mu.Lock()
+	a, ok := ka.keys[key]
+	delete(ka.keys, key)
+	ka.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	a.cancel()
+	<-a.done
+	return nil
+}
+
+// Wait waits until the keys are deleted after the ctx of Start is done.
+func (ka *KeepAlives) Wait() {
+	ka.wg.Wait()
+}
@@ -0,0 +1,23 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepAliveInterval(t *testing.T) {
+	for ttl, expected := range map[time.Duration]time.Duration{
+		time.Second * 3:       time.Second,
+		time.Millisecond * 30: MinKeepAliveInterval,
+		time.Nanosecond:       MinKeepAliveInterval,
+	} {
+		if interval, err := KeepAliveInterval(ttl); err != nil || interval != expected {
+			t.Fatal("ttl:", ttl, "actual:", interval, err)
+		}
+	}
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		if _, err := KeepAliveInterval(ttl); err != ErrInvalidTTL {
+			t.Fatal("ttl:", ttl, "actual:", err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package kubernetes
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/appootb/grc/backend"
+)
+
+func init() {
+	backend.Register(backend.Kubernetes, Open)
+}
+
+// Open creates an in-cluster provider from the DSN, the host is the namespace,
+// kubernetes://default/base
+func Open(ctx context.Context, dsn *url.URL) (backend.Provider, string, error) {
+	provider, err := NewInClusterProvider(ctx, dsn.Host)
+	if err != nil {
+		return nil, "", err
+	}
+	return provider, backend.BasePath(dsn), nil
+}
@@ -0,0 +1,577 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	ManagedLabel    = "grc.appootb.com/managed"
+	PathAnnotation  = "grc.appootb.com/path"
+	KeyAnnotation   = "grc.appootb.com/key"
+	ValueAnnotation = "grc.appootb.com/value"
+)
+
+type watch struct {
+	ctx    context.Context
+	ch     backend.EventChan
+	key    string
+	prefix bool
+}
+
+// Kubernetes stores the keys without ttl in ConfigMaps, one ConfigMap per key
+// directory, and the keys with ttl in coordination.k8s.io Leases.
+type Kubernetes struct {
+	client    k8s.Interface
+	namespace string
+	identity  string
+	ws        []*watch
+	watches   backend.Watches
+	leases    cache.Store
+	alive     backend.KeepAlives
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	sync.RWMutex
+}
+
+// NewInClusterProvider connects to the cluster the process is running in.
+func NewInClusterProvider(ctx context.Context, namespace string) (backend.Provider, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := k8s.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewProvider(ctx, client, namespace)
+}
+
+func NewProvider(ctx context.Context, client k8s.Interface, namespace string) (backend.Provider, error) {
+	identity, _ := os.Hostname()
+	p := &Kubernetes{
+		client:    client,
+		namespace: namespace,
+		identity:  identity,
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = ManagedLabel + "=true"
+		}))
+	configMaps := factory.Core().V1().ConfigMaps().Informer()
+	configMaps.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			p.onConfigMap(nil, obj)
+		},
+		UpdateFunc: p.onConfigMap,
+		DeleteFunc: func(obj interface{}) {
+			p.onConfigMap(obj, nil)
+		},
+	})
+	leases := factory.Coordination().V1().Leases().Informer()
+	p.leases = leases.GetStore()
+	leases.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			p.onLease(nil, obj)
+		},
+		UpdateFunc: p.onLease,
+		DeleteFunc: func(obj interface{}) {
+			p.onLease(obj, nil)
+		},
+	})
+	factory.Start(p.ctx.Done())
+	syncCtx, syncCancel := context.WithTimeout(p.ctx, backend.DialTimeout)
+	defer syncCancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), configMaps.HasSynced, leases.HasSynced) {
+		p.cancel()
+		return nil, fmt.Errorf("grc: kubernetes informer cache sync failed")
+	}
+	go p.checkTTL()
+	return p, nil
+}
+
+// Type returns the provider type.
+func (p *Kubernetes) Type() string {
+	return backend.Kubernetes
+}
+
+// Set value for the specified key with a specified ttl.
+func (p *Kubernetes) Set(key, value string, ttl time.Duration) error {
+	if ttl > 0 {
+		return p.setLease(key, value, ttl)
+	}
+	dir, name := splitKey(key)
+	return p.updateConfigMap(dir, true, func(cm *corev1.ConfigMap) error {
+		cm.Data[encodeName(name)] = value
+		return nil
+	})
+}
+
+// Get the value of the specified key or directory.
+func (p *Kubernetes) Get(key string, dir bool) (backend.KVPairs, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.ReadTimeout)
+	defer cancel()
+	kvs := backend.KVPairs{}
+	if !dir {
+		lease, err := p.client.CoordinationV1().Leases(p.namespace).Get(ctx, leaseName(key), metav1.GetOptions{})
+		if err == nil && !expired(lease) {
+			return append(kvs, &backend.KVPair{
+				Key:   key,
+				Value: lease.Annotations[ValueAnnotation],
+			}), nil
+		} else if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		d, name := splitKey(key)
+		cm, err := p.client.CoreV1().ConfigMaps(p.namespace).Get(ctx, configMapName(d), metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return kvs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if v, ok := cm.Data[encodeName(name)]; ok {
+			kvs = append(kvs, &backend.KVPair{
+				Key:   key,
+				Value: v,
+			})
+		}
+		return kvs, nil
+	}
+	//
+	opts := metav1.ListOptions{
+		LabelSelector: ManagedLabel + "=true",
+	}
+	configMaps, err := p.client.CoreV1().ConfigMaps(p.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range configMaps.Items {
+		for k, v := range configMapKVs(&configMaps.Items[i]) {
+			if strings.HasPrefix(k, key) {
+				kvs = append(kvs, &backend.KVPair{
+					Key:   k,
+					Value: v,
+				})
+			}
+		}
+	}
+	leases, err := p.client.CoordinationV1().Leases(p.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		if k := lease.Annotations[KeyAnnotation]; strings.HasPrefix(k, key) && !expired(lease) {
+			kvs = append(kvs, &backend.KVPair{
+				Key:   k,
+				Value: lease.Annotations[ValueAnnotation],
+			})
+		}
+	}
+	return kvs, nil
+}
+
+// Incr invokes an atomic value increase for the specified key.
+func (p *Kubernetes) Incr(key string) (int64, error) {
+	var num int64
+	dir, name := splitKey(key)
+	// Conflicts of the resource version are retried.
+	err := p.updateConfigMap(dir, true, func(cm *corev1.ConfigMap) error {
+		num, _ = strconv.ParseInt(cm.Data[encodeName(name)], 10, 64)
+		num++
+		cm.Data[encodeName(name)] = strconv.FormatInt(num, 10)
+		return nil
+	})
+	return num, err
+}
+
+// Delete the specified key or directory.
+func (p *Kubernetes) Delete(key string, dir bool) error {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	defer cancel()
+	if !dir {
+		err := p.client.CoordinationV1().Leases(p.namespace).Delete(ctx, leaseName(key), metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		d, name := splitKey(key)
+		return p.updateConfigMap(d, false, func(cm *corev1.ConfigMap) error {
+			delete(cm.Data, encodeName(name))
+			return nil
+		})
+	}
+	//
+	opts := metav1.ListOptions{
+		LabelSelector: ManagedLabel + "=true",
+	}
+	leases, err := p.client.CoordinationV1().Leases(p.namespace).List(ctx, opts)
+	if err != nil {
+		return err
+	}
+	for _, lease := range leases.Items {
+		if strings.HasPrefix(lease.Annotations[KeyAnnotation], key) {
+			err = p.client.CoordinationV1().Leases(p.namespace).Delete(ctx, lease.Name, metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	configMaps, err := p.client.CoreV1().ConfigMaps(p.namespace).List(ctx, opts)
+	if err != nil {
+		return err
+	}
+	for _, cm := range configMaps.Items {
+		d := cm.Annotations[PathAnnotation]
+		if !strings.HasPrefix(d, key) && !strings.HasPrefix(key, d) {
+			continue
+		}
+		err = p.updateConfigMap(d, false, func(cm *corev1.ConfigMap) error {
+			for name := range cm.Data {
+				if strings.HasPrefix(d+decodeName(name), key) {
+					delete(cm.Data, name)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch for changes of the specified key or directory.
+func (p *Kubernetes) Watch(key string, dir bool) (backend.EventChan, error) {
+	p.Lock()
+	defer p.Unlock()
+	ctx, ch := p.watches.Add(p.ctx)
+	p.ws = append(p.ws, &watch{
+		ctx:    ctx,
+		ch:     ch,
+		key:    key,
+		prefix: dir,
+	})
+	return ch, nil
+}
+
+// Unwatch stops the watch of the channel returned by Watch.
+func (p *Kubernetes) Unwatch(ch backend.EventChan) error {
+	// Cancel first, which releases the notify blocked on the channel.
+	p.watches.Remove(ch)
+	p.Lock()
+	defer p.Unlock()
+	for i, w := range p.ws {
+		if w.ch == ch {
+			p.ws = append(p.ws[:i], p.ws[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// KeepAlive sets value and updates the ttl for the specified key.
+func (p *Kubernetes) KeepAlive(key, value string, ttl time.Duration) error {
+	return p.alive.Start(p.ctx, key, value, ttl, p.setLease, func(key string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), backend.WriteTimeout)
+		defer cancel()
+		err := p.client.CoordinationV1().Leases(p.namespace).Delete(ctx, leaseName(key), metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+// UpdateKeepAlive updates the value of the key kept alive, the ttl is kept.
+func (p *Kubernetes) UpdateKeepAlive(key, value string) error {
+	return p.alive.Update(key, value)
+}
+
+// StopKeepAlive stops keeping the key alive and deletes it.
+func (p *Kubernetes) StopKeepAlive(key string) error {
+	return p.alive.Stop(key)
+}
+
+// Close the provider connection.
+func (p *Kubernetes) Close() error {
+	p.cancel()
+	// Wait for the keys kept alive to be deleted.
+	p.alive.Wait()
+	return nil
+}
+
+// checkTTL deletes the expired Leases of the informer cache.
+func (p *Kubernetes) checkTTL() {
+	ticker := time.NewTicker(time.Second)
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			ticker.Stop()
+			return
+		case <-ticker.C:
+			for _, obj := range p.leases.List() {
+				lease := toLease(obj)
+				if lease == nil || !expired(lease) {
+					continue
+				}
+				ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+				err := p.client.CoordinationV1().Leases(p.namespace).Delete(ctx, lease.Name, metav1.DeleteOptions{
+					Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+				})
+				cancel()
+				if err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+					log.Println("grc: kubernetes checkTTL failed, ", err.Error())
+				}
+			}
+		}
+	}
+}
+
+func (p *Kubernetes) setLease(key, value string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	defer cancel()
+	leases := p.client.CoordinationV1().Leases(p.namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		now := metav1.NewMicroTime(time.Now())
+		seconds := int32(ttl.Seconds())
+		if seconds < 1 {
+			seconds = 1
+		}
+		lease, err := leases.Get(ctx, leaseName(key), metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			_, err = leases.Create(ctx, &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   leaseName(key),
+					Labels: map[string]string{ManagedLabel: "true"},
+					Annotations: map[string]string{
+						KeyAnnotation:   key,
+						ValueAnnotation: value,
+					},
+				},
+				Spec: coordinationv1.LeaseSpec{
+					HolderIdentity:       &p.identity,
+					LeaseDurationSeconds: &seconds,
+					AcquireTime:          &now,
+					RenewTime:            &now,
+				},
+			}, metav1.CreateOptions{})
+			if errors.IsAlreadyExists(err) {
+				return errors.NewConflict(coordinationv1.Resource("leases"), leaseName(key), err)
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+		lease.Annotations[ValueAnnotation] = value
+		lease.Spec.HolderIdentity = &p.identity
+		lease.Spec.LeaseDurationSeconds = &seconds
+		lease.Spec.RenewTime = &now
+		_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// updateConfigMap applies fn to the ConfigMap of the key directory,
+// an empty ConfigMap is removed.
+func (p *Kubernetes) updateConfigMap(dir string, create bool, fn func(cm *corev1.ConfigMap) error) error {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	defer cancel()
+	configMaps := p.client.CoreV1().ConfigMaps(p.namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, configMapName(dir), metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			if !create {
+				return nil
+			}
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        configMapName(dir),
+					Labels:      map[string]string{ManagedLabel: "true"},
+					Annotations: map[string]string{PathAnnotation: dir},
+				},
+				Data: map[string]string{},
+			}
+			if err = fn(cm); err != nil {
+				return err
+			}
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			if errors.IsAlreadyExists(err) {
+				return errors.NewConflict(corev1.Resource("configmaps"), cm.Name, err)
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		if err = fn(cm); err != nil {
+			return err
+		}
+		if len(cm.Data) == 0 {
+			return configMaps.Delete(ctx, cm.Name, metav1.DeleteOptions{
+				Preconditions: &metav1.Preconditions{ResourceVersion: &cm.ResourceVersion},
+			})
+		}
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (p *Kubernetes) onConfigMap(oldObj, newObj interface{}) {
+	prev, current := configMapKVs(oldObj), configMapKVs(newObj)
+	for k, v := range current {
+		if pv, ok := prev[k]; !ok || pv != v {
+			p.notify(backend.Put, k, v)
+		}
+	}
+	for k, v := range prev {
+		if _, ok := current[k]; !ok {
+			p.notify(backend.Delete, k, v)
+		}
+	}
+}
+
+func (p *Kubernetes) onLease(oldObj, newObj interface{}) {
+	prev, current := toLease(oldObj), toLease(newObj)
+	switch {
+	case current == nil && prev != nil:
+		p.notify(backend.Delete, prev.Annotations[KeyAnnotation], prev.Annotations[ValueAnnotation])
+	case current != nil && !expired(current):
+		// Renewals without value changes are ignored.
+		if prev == nil || expired(prev) ||
+			prev.Annotations[ValueAnnotation] != current.Annotations[ValueAnnotation] {
+			p.notify(backend.Put, current.Annotations[KeyAnnotation], current.Annotations[ValueAnnotation])
+		}
+	}
+}
+
+func (p *Kubernetes) notify(typ backend.EventType, key, value string) {
+	evt := &backend.WatchEvent{
+		Type: typ,
+		KVPair: backend.KVPair{
+			Key:   key,
+			Value: value,
+		},
+	}
+	// Sent without the lock, so the slow consumers don't block Watch and Unwatch.
+	var matched []*watch
+	p.RLock()
+	for _, w := range p.ws {
+		if evt.Key == w.key ||
+			w.prefix && strings.HasPrefix(evt.Key, w.key) {
+			matched = append(matched, w)
+		}
+	}
+	p.RUnlock()
+	for _, w := range matched {
+		backend.SendEvent(w.ctx, w.ch, evt)
+	}
+}
+
+func configMapKVs(obj interface{}) map[string]string {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	kvs := map[string]string{}
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok || cm.Labels[ManagedLabel] != "true" {
+		return kvs
+	}
+	dir := cm.Annotations[PathAnnotation]
+	for name, v := range cm.Data {
+		kvs[dir+decodeName(name)] = v
+	}
+	return kvs
+}
+
+func toLease(obj interface{}) *coordinationv1.Lease {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	lease, ok := obj.(*coordinationv1.Lease)
+	if !ok || lease.Labels[ManagedLabel] != "true" {
+		return nil
+	}
+	return lease
+}
+
+func expired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	ttl := time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	return time.Since(lease.Spec.RenewTime.Time) > ttl
+}
+
+func splitKey(key string) (string, string) {
+	i := strings.LastIndex(key, "/") + 1
+	return key[:i], key[i:]
+}
+
+func objectName(prefix, s string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return fmt.Sprintf("%s-%016x", prefix, h.Sum64())
+}
+
+func configMapName(dir string) string {
+	return objectName("grc-config", dir)
+}
+
+func leaseName(key string) string {
+	return objectName("grc-lease", key)
+}
+
+// ConfigMap keys only allow [-._a-zA-Z0-9], the other bytes and '_'
+// are escaped as '_' followed by two hex digits.
+func encodeName(name string) string {
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == '-' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			sb.WriteByte(c)
+		} else {
+			_, _ = fmt.Fprintf(&sb, "_%02x", c)
+		}
+	}
+	return sb.String()
+}
+
+func decodeName(name string) string {
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '_' && i+2 < len(name) {
+			if c, err := strconv.ParseUint(name[i+1:i+3], 16, 8); err == nil {
+				sb.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		sb.WriteByte(name[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,255 @@
+package kubernetes
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"github.com/appootb/grc/backend/backendtest"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const namespace = "grc"
+
+func newTestProvider(t *testing.T) (*fake.Clientset, backend.Provider) {
+	client := fake.NewSimpleClientset()
+	p, err := NewProvider(context.Background(), client, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+	return client, p
+}
+
+func TestKubernetes(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Provider {
+		_, p := newTestProvider(t)
+		return p
+	})
+}
+
+func TestKubernetes_EncodeName(t *testing.T) {
+	for _, name := range []string{"A", "node_id", "a b/c", "x_2f"} {
+		encoded := encodeName(name)
+		if decodeName(encoded) != name {
+			t.Fatal("actual:", encoded, decodeName(encoded))
+		}
+	}
+}
+
+// The keys with ttl are Leases, listed with the keys of the ConfigMaps.
+func TestKubernetes_Leases(t *testing.T) {
+	_, p := newTestProvider(t)
+
+	if err := p.Set("/test/config/svc/A", "a", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Set("/test/config/svc/ES/b_c", "b", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Set("/test/service/svc/node1", "n1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	kvs, err := p.Get("/test/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(kvs, func(i, j int) bool {
+		return kvs[i].Key < kvs[j].Key
+	})
+	if len(kvs) != 3 || kvs[1].Key != "/test/config/svc/ES/b_c" || kvs[2].Value != "n1" {
+		t.Fatal("actual:", kvs)
+	}
+	kvs, err = p.Get("/test/service/svc/node1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || kvs[0].Value != "n1" {
+		t.Fatal("actual:", kvs)
+	}
+	if err = p.Delete("/test/config/", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Delete("/test/service/svc/node1", false); err != nil {
+		t.Fatal(err)
+	}
+	kvs, err = p.Get("/test/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 0 {
+		t.Fatal("actual:", kvs)
+	}
+}
+
+func TestKubernetes_WatchEdit(t *testing.T) {
+	client, p := newTestProvider(t)
+
+	ch, err := p.Watch("/test/config/svc/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Set("/test/config/svc/A", "a", 0); err != nil {
+		t.Fatal(err)
+	}
+	evt := backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Put || evt.Key != "/test/config/svc/A" || evt.Value != "a" {
+		t.Fatal("actual:", evt)
+	}
+	// Edited with kubectl.
+	ctx := context.Background()
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, configMapName("/test/config/svc/"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.Data["A"] = "b"
+	if _, err = client.CoreV1().ConfigMaps(namespace).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	evt = backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Put || evt.Value != "b" {
+		t.Fatal("actual:", evt)
+	}
+	if err = p.Delete("/test/config/svc/A", false); err != nil {
+		t.Fatal(err)
+	}
+	evt = backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Delete || evt.Key != "/test/config/svc/A" {
+		t.Fatal("actual:", evt)
+	}
+	// The empty ConfigMap is removed.
+	_, err = client.CoreV1().ConfigMaps(namespace).Get(ctx, cm.Name, metav1.GetOptions{})
+	if err == nil {
+		t.Fatal("ConfigMap not removed")
+	}
+	// Unmanaged ConfigMaps are ignored.
+	_, err = client.CoreV1().ConfigMaps(namespace).Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "other",
+			Annotations: map[string]string{PathAnnotation: "/test/config/svc/"},
+		},
+		Data: map[string]string{"A": "c"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case evt = <-ch:
+		t.Fatal("actual:", evt)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+func TestKubernetes_KeepAlive(t *testing.T) {
+	client, p := newTestProvider(t)
+
+	ch, err := p.Watch("/test/service/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.KeepAlive("/test/service/svc/node1", "n1", time.Second*3); err != nil {
+		t.Fatal(err)
+	}
+	evt := backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Put || evt.Key != "/test/service/svc/node1" || evt.Value != "n1" {
+		t.Fatal("actual:", evt)
+	}
+	// The lease is deleted when the provider stops.
+	_ = p.Close()
+	deadline := time.Now().Add(time.Second * 3)
+	for {
+		_, err = client.CoordinationV1().Leases(namespace).Get(context.Background(),
+			leaseName("/test/service/svc/node1"), metav1.GetOptions{})
+		if err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lease not deleted")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+// The watches not read any more must not block the notifications of the others.
+func TestKubernetes_WatchCanceled(t *testing.T) {
+	_, p := newTestProvider(t)
+	cp := backend.NewContextProvider(p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := cp.Watch(ctx, "/test/config/", backend.WithPrefix()); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	ch, err := p.Watch("/test/config/svc/A", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More events than the channel of the canceled watch can buffer.
+	for i := 0; i <= backend.DefaultChanLen; i++ {
+		if err = p.Set("/test/config/svc/B", strconv.Itoa(i), 0); err != nil {
+			t.Fatal(err)
+		}
+		// The fake watches of the informer buffer 100 events.
+		if i%50 == 0 {
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+	if err = p.Set("/test/config/svc/A", "a", 0); err != nil {
+		t.Fatal(err)
+	}
+	evt := backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Put || evt.Value != "a" {
+		t.Fatal("actual:", evt)
+	}
+}
+
+// The expired Leases are found in the informer cache, not listed every second.
+func TestKubernetes_CheckTTL(t *testing.T) {
+	client, p := newTestProvider(t)
+
+	ch, err := p.Watch("/test/service/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seconds := int32(1)
+	renew := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	_, err = client.CoordinationV1().Leases(namespace).Create(context.Background(), &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   leaseName("/test/service/svc/node1"),
+			Labels: map[string]string{ManagedLabel: "true"},
+			Annotations: map[string]string{
+				KeyAnnotation:   "/test/service/svc/node1",
+				ValueAnnotation: "n1",
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			LeaseDurationSeconds: &seconds,
+			RenewTime:            &renew,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt := backendtest.WaitEvent(t, ch); evt.Type != backend.Delete || evt.Key != "/test/service/svc/node1" {
+		t.Fatal("actual:", evt)
+	}
+	time.Sleep(time.Second)
+	lists := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "leases" {
+			lists++
+		}
+	}
+	// Listed by the informer only.
+	if lists != 1 {
+		t.Fatal("actual:", lists)
+	}
+}
@@ -0,0 +1,31 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrPermitLost is returned if the lease of the permit is lost while acquiring.
+	ErrPermitLost = errors.New("grc: permit lease lost")
+)
+
+// Permit of a semaphore, held under a lease until released.
+type Permit interface {
+	// Token returns the fencing token, increasing among the acquisitions of the provider.
+	Token() int64
+
+	// Done is closed when the permit is released or the lease is lost.
+	Done() <-chan struct{}
+
+	// Release the permit.
+	Release(ctx context.Context) error
+}
+
+// Locker is implemented by the providers which support distributed locks.
+type Locker interface {
+	// Acquire blocks until one of the n permits of the prefix is acquired or the ctx is done,
+	// the permit is lost if not kept alive within the ttl. A mutex is the semaphore of 1 permit.
+	Acquire(ctx context.Context, prefix string, n int, ttl time.Duration) (Permit, error)
+}
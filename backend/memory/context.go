@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/appootb/grc/backend"
+)
+
+type contextProvider struct {
+	p *Memory
+}
+
+// Context returns the context-aware view of the provider.
+func (p *Memory) Context() backend.ContextProvider {
+	return &contextProvider{
+		p: p,
+	}
+}
+
+// Type returns the provider type.
+func (c *contextProvider) Type() string {
+	return c.p.Type()
+}
+
+// Set value for the specified key, WithTTL sets the ttl.
+func (c *contextProvider) Set(ctx context.Context, key, value string, opts ...backend.OpOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.p.Set(key, value, backend.NewOp(opts...).TTL)
+}
+
+// Get the value of the specified key, or directory WithPrefix.
+func (c *contextProvider) Get(ctx context.Context, key string, opts ...backend.OpOption) (backend.KVPairs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.p.Get(key, backend.NewOp(opts...).Prefix)
+}
+
+// Incr invokes an atomic value increase for the specified key.
+func (c *contextProvider) Incr(ctx context.Context, key string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.p.Incr(key)
+}
+
+// Delete the specified key, or directory WithPrefix.
+func (c *contextProvider) Delete(ctx context.Context, key string, opts ...backend.OpOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.p.Delete(key, backend.NewOp(opts...).Prefix)
+}
+
+// Watch for changes of the specified key, or directory WithPrefix,
+// until the ctx is done, then the channel is closed.
+func (c *contextProvider) Watch(ctx context.Context, key string, opts ...backend.OpOption) (backend.EventChan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	w := &watch{
+		ch:     make(backend.EventChan, 10),
+		key:    key,
+		prefix: backend.NewOp(opts...).Prefix,
+		done:   ctx.Done(),
+	}
+	c.p.Lock()
+	c.p.ws = append(c.p.ws, w)
+	c.p.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.p.ctx.Done():
+		}
+		c.p.Lock()
+		defer c.p.Unlock()
+		for i, v := range c.p.ws {
+			if v == w {
+				c.p.ws = append(c.p.ws[:i], c.p.ws[i+1:]...)
+				break
+			}
+		}
+		close(w.ch)
+	}()
+	return w.ch, nil
+}
+
+// KeepAlive sets value and updates the ttl for the specified key,
+// the ctx bounds the first write, the key is kept alive until the provider is closed.
+func (c *contextProvider) KeepAlive(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.p.KeepAlive(key, value, ttl)
+}
+
+// Close the provider connection.
+func (c *contextProvider) Close() error {
+	return c.p.Close()
+}
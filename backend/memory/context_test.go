@@ -0,0 +1,33 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appootb/grc/backend"
+)
+
+func TestMemory_WatcherClose(t *testing.T) {
+	p := NewProvider()
+	defer p.Close()
+
+	w, err := backend.NewWatcher(context.Background(), backend.NewContextProvider(p), "/test/", backend.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not consumed.
+	for i := 0; i < 5; i++ {
+		if err = p.Set("/test/A", "a", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	m := p.(*Memory)
+	m.RLock()
+	defer m.RUnlock()
+	if len(m.ws) != 0 {
+		t.Fatal("actual:", len(m.ws))
+	}
+}
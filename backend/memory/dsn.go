@@ -0,0 +1,17 @@
+package memory
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/appootb/grc/backend"
+)
+
+func init() {
+	backend.Register(backend.Memory, Open)
+}
+
+// Open creates a memory provider from the DSN, memory:///base
+func Open(_ context.Context, dsn *url.URL) (backend.Provider, string, error) {
+	return NewProvider(), backend.BasePath(dsn), nil
+}
@@ -0,0 +1,151 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/appootb/grc/backend"
+)
+
+// electionState holds the candidates in order, the first is the leader.
+type electionState struct {
+	candidates []*election
+	notify     chan struct{}
+}
+
+func (s *electionState) leader() *election {
+	if len(s.candidates) == 0 {
+		return nil
+	}
+	return s.candidates[0]
+}
+
+// changed wakes up the waiters.
+func (s *electionState) changed() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// NewElection returns the election of the prefix, the candidates are kept until resigned.
+func (p *Memory) NewElection(_ context.Context, prefix string, _ time.Duration) (backend.Election, error) {
+	return &election{
+		p:      p,
+		prefix: prefix,
+	}, nil
+}
+
+// Leader returns the value of the current leader of the prefix.
+func (p *Memory) Leader(_ context.Context, prefix string) (string, error) {
+	p.Lock()
+	defer p.Unlock()
+	if leader := p.election(prefix).leader(); leader != nil {
+		return leader.value, nil
+	}
+	return "", backend.ErrNoLeader
+}
+
+// election returns the state of the prefix, must be called with the lock held.
+func (p *Memory) election(prefix string) *electionState {
+	s, ok := p.elections[prefix]
+	if !ok {
+		s = &electionState{
+			notify: make(chan struct{}),
+		}
+		p.elections[prefix] = s
+	}
+	return s
+}
+
+type election struct {
+	p      *Memory
+	prefix string
+	value  string
+	lost   chan struct{}
+}
+
+func (e *election) Campaign(ctx context.Context, value string) (<-chan struct{}, error) {
+	e.p.Lock()
+	s := e.p.election(e.prefix)
+	e.value = value
+	if e.lost == nil {
+		e.lost = make(chan struct{})
+		s.candidates = append(s.candidates, e)
+		if s.leader() == e {
+			s.changed()
+		}
+	}
+	for {
+		if s.leader() == e {
+			lost := e.lost
+			e.p.Unlock()
+			return lost, nil
+		}
+		wait := s.notify
+		e.p.Unlock()
+		select {
+		case <-wait:
+			e.p.Lock()
+		case <-ctx.Done():
+			_ = e.Resign(context.Background())
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (e *election) Resign(context.Context) error {
+	e.p.Lock()
+	defer e.p.Unlock()
+	if e.lost == nil {
+		return nil
+	}
+	s := e.p.election(e.prefix)
+	for i, c := range s.candidates {
+		if c == e {
+			s.candidates = append(s.candidates[:i:i], s.candidates[i+1:]...)
+			if i == 0 {
+				s.changed()
+			}
+			break
+		}
+	}
+	close(e.lost)
+	e.lost = nil
+	return nil
+}
+
+func (e *election) Observe(ctx context.Context) <-chan string {
+	ch := make(chan string, backend.DefaultChanLen)
+	go func() {
+		defer close(ch)
+
+		last := ""
+		for {
+			e.p.Lock()
+			s := e.p.election(e.prefix)
+			value := ""
+			if leader := s.leader(); leader != nil {
+				value = leader.value
+			}
+			wait := s.notify
+			e.p.Unlock()
+			if value != "" && value != last {
+				select {
+				case ch <- value:
+					last = value
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-wait:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (e *election) Close() error {
+	return e.Resign(context.Background())
+}
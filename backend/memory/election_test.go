@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc/backend"
+)
+
+func TestMemory_Election(t *testing.T) {
+	p := NewProvider()
+	defer p.Close()
+
+	m := p.(*Memory)
+	ctx := context.Background()
+	if _, err := m.Leader(ctx, "/test/election"); err != backend.ErrNoLeader {
+		t.Fatal("actual:", err)
+	}
+	e1, _ := m.NewElection(ctx, "/test/election", time.Second)
+	e2, _ := m.NewElection(ctx, "/test/election", time.Second)
+	observeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	observe := e2.Observe(observeCtx)
+
+	lost1, err := e1.Campaign(ctx, "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	elected := make(chan error, 1)
+	go func() {
+		_, err := e2.Campaign(ctx, "node2")
+		elected <- err
+	}()
+	if leader, err := m.Leader(ctx, "/test/election"); err != nil || leader != "node1" {
+		t.Fatal("actual:", leader, err)
+	}
+	waitLeader := func(expect string) {
+		select {
+		case leader := <-observe:
+			if leader != expect {
+				t.Fatal("actual:", leader)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("observe timeout")
+		}
+	}
+	waitLeader("node1")
+
+	if err = e1.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lost1:
+	default:
+		t.Fatal("leadership not lost")
+	}
+	if err = <-elected; err != nil {
+		t.Fatal(err)
+	}
+	waitLeader("node2")
+
+	// Canceled campaign withdraws the candidacy.
+	cancelCtx, cancelCampaign := context.WithCancel(ctx)
+	cancelCampaign()
+	if _, err = e1.Campaign(cancelCtx, "node1"); err != context.Canceled {
+		t.Fatal("actual:", err)
+	}
+	if err = e2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Leader(ctx, "/test/election"); err != backend.ErrNoLeader {
+		t.Fatal("actual:", err)
+	}
+}
@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc/backend"
+)
+
+func TestMemory_UpdateKeepAlive(t *testing.T) {
+	p := NewProvider()
+	defer p.Close()
+
+	ch, err := p.Watch("/test/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := p.(*Memory)
+	if err = m.UpdateKeepAlive("/test/A", "b"); err != backend.ErrNotKeptAlive {
+		t.Fatal("actual:", err)
+	}
+	if err = p.KeepAlive("/test/A", "a", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.UpdateKeepAlive("/test/A", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.StopKeepAlive("/test/A"); err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []backend.WatchEvent{
+		{Type: backend.Put, KVPair: backend.KVPair{Key: "/test/A", Value: "a"}},
+		{Type: backend.Put, KVPair: backend.KVPair{Key: "/test/A", Value: "b"}},
+		{Type: backend.Delete, KVPair: backend.KVPair{Key: "/test/A", Value: "b"}},
+	} {
+		select {
+		case evt := <-ch:
+			if evt.Type != expect.Type || evt.Key != expect.Key || evt.Value != expect.Value {
+				t.Fatal("actual:", evt)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("watch event timeout")
+		}
+	}
+}
+
+func TestMemory_KeepAliveSlot(t *testing.T) {
+	p := NewProvider()
+	defer p.Close()
+
+	m := p.(*Memory)
+	ctx := context.Background()
+	value := func(slot int64) string {
+		return strconv.FormatInt(slot, 10)
+	}
+	slots := make([]*backend.Slot, 0, 2)
+	for i, key := range []string{"/test/svc/A", "/test/svc/B"} {
+		slot, err := m.KeepAliveSlot(ctx, key, "/test/ids/", 2, time.Second, value)
+		if err != nil || slot.ID != int64(i) {
+			t.Fatal("actual:", slot, err)
+		}
+		slots = append(slots, slot)
+	}
+	if _, err := m.KeepAliveSlot(ctx, "/test/svc/C", "/test/ids/", 2, time.Second, value); err != backend.ErrSlotsExhausted {
+		t.Fatal("actual:", err)
+	}
+	if err := m.StopKeepAlive("/test/svc/A"); err != nil {
+		t.Fatal(err)
+	}
+	// Released.
+	<-slots[0].Done()
+	slot, err := m.KeepAliveSlot(ctx, "/test/svc/C", "/test/ids/", 2, time.Second, value)
+	if err != nil || slot.ID != 0 {
+		t.Fatal("actual:", slot, err)
+	}
+	kvs, err := p.Get("/test/svc/C", false)
+	if err != nil || len(kvs) != 1 || kvs[0].Value != "0" {
+		t.Fatal("actual:", kvs, err)
+	}
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/appootb/grc/backend"
+)
+
+// semaphoreState holds the permits in order of the acquisitions, the first n are the holders.
+type semaphoreState struct {
+	permits []*permit
+	notify  chan struct{}
+}
+
+// Acquire blocks until one of the n permits of the prefix is acquired,
+// the revision of the acquisition is the fencing token.
+func (p *Memory) Acquire(ctx context.Context, prefix string, n int, _ time.Duration) (backend.Permit, error) {
+	p.Lock()
+	s, ok := p.semaphores[prefix]
+	if !ok {
+		s = &semaphoreState{
+			notify: make(chan struct{}),
+		}
+		p.semaphores[prefix] = s
+	}
+	p.rev++
+	pm := &permit{
+		p:      p,
+		prefix: prefix,
+		token:  p.rev,
+		done:   make(chan struct{}),
+	}
+	s.permits = append(s.permits, pm)
+	for {
+		for i, holder := range s.permits {
+			if holder == pm && i < n {
+				p.Unlock()
+				return pm, nil
+			}
+		}
+		wait := s.notify
+		p.Unlock()
+		select {
+		case <-wait:
+			p.Lock()
+		case <-ctx.Done():
+			_ = pm.Release(context.Background())
+			return nil, ctx.Err()
+		}
+	}
+}
+
+type permit struct {
+	p      *Memory
+	prefix string
+	token  int64
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (pm *permit) Token() int64 {
+	return pm.token
+}
+
+func (pm *permit) Done() <-chan struct{} {
+	return pm.done
+}
+
+func (pm *permit) Release(context.Context) error {
+	pm.once.Do(func() {
+		pm.p.Lock()
+		defer pm.p.Unlock()
+		s := pm.p.semaphores[pm.prefix]
+		for i, holder := range s.permits {
+			if holder == pm {
+				s.permits = append(s.permits[:i:i], s.permits[i+1:]...)
+				break
+			}
+		}
+		if len(s.permits) == 0 {
+			delete(pm.p.semaphores, pm.prefix)
+		}
+		// Wake up the waiters.
+		close(s.notify)
+		s.notify = make(chan struct{})
+		close(pm.done)
+	})
+	return nil
+}
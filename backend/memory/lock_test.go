@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemory_Acquire(t *testing.T) {
+	p := NewProvider()
+	defer p.Close()
+
+	m := p.(*Memory)
+	ctx := context.Background()
+	pm1, err := m.Acquire(ctx, "/test/lock", 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm2, err := m.Acquire(ctx, "/test/lock", 2, time.Second)
+	if err != nil || pm2.Token() <= pm1.Token() {
+		t.Fatal("actual:", pm1.Token(), pm2.Token(), err)
+	}
+	// Canceled while waiting.
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	if _, err = m.Acquire(timeoutCtx, "/test/lock", 2, time.Second); err != context.DeadlineExceeded {
+		t.Fatal("actual:", err)
+	}
+	acquired := make(chan int64, 1)
+	go func() {
+		pm3, err := m.Acquire(ctx, "/test/lock", 2, time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- pm3.Token()
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired more than 2 permits")
+	case <-time.After(time.Millisecond * 50):
+	}
+	if err = pm1.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-pm1.Done():
+	default:
+		t.Fatal("permit not done")
+	}
+	select {
+	case token := <-acquired:
+		if token <= pm2.Token() {
+			t.Fatal("actual:", token)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire timeout")
+	}
+}
@@ -17,18 +17,31 @@ var (
 type node struct {
 	k      string
 	v      string
+	rev    int64
 	expire time.Time
 }
 
+// alive is a key kept alive, with the slot claimed by KeepAliveSlot.
+type alive struct {
+	slot string
+	lost func()
+}
+
 type watch struct {
 	ch     backend.EventChan
 	key    string
 	prefix bool
+	done   <-chan struct{}
 }
 
 type Memory struct {
-	kvs map[string]*node
-	ws  []*watch
+	kvs   map[string]*node
+	ws    []*watch
+	rev   int64
+	alive map[string]*alive
+
+	elections  map[string]*electionState
+	semaphores map[string]*semaphoreState
 
 	event  backend.EventChan
 	ctx    context.Context
@@ -38,8 +51,11 @@ type Memory struct {
 
 func NewProvider() backend.Provider {
 	p := &Memory{
-		kvs:   make(map[string]*node),
-		event: make(backend.EventChan, 10),
+		kvs:        make(map[string]*node),
+		alive:      make(map[string]*alive),
+		elections:  make(map[string]*electionState),
+		semaphores: make(map[string]*semaphoreState),
+		event:      make(backend.EventChan, 10),
 	}
 	p.ctx, p.cancel = context.WithCancel(context.Background())
 	go p.checkTTL()
@@ -59,17 +75,21 @@ func (p *Memory) Set(key, value string, ttl time.Duration) error {
 		expire = zeroTime
 	}
 	p.Lock()
+	p.rev++
+	rev := p.rev
 	p.kvs[key] = &node{
 		k:      key,
 		v:      value,
+		rev:    rev,
 		expire: expire,
 	}
 	p.Unlock()
 	p.event <- &backend.WatchEvent{
 		Type: backend.Put,
 		KVPair: backend.KVPair{
-			Key:   key,
-			Value: value,
+			Key:      key,
+			Value:    value,
+			Revision: rev,
 		},
 	}
 	return nil
@@ -85,8 +105,9 @@ func (p *Memory) Get(key string, dir bool) (backend.KVPairs, error) {
 		} else {
 			return backend.KVPairs{
 				{
-					Key:   key,
-					Value: n.v,
+					Key:      key,
+					Value:    n.v,
+					Revision: n.rev,
 				},
 			}, nil
 		}
@@ -96,8 +117,9 @@ func (p *Memory) Get(key string, dir bool) (backend.KVPairs, error) {
 	for k, v := range p.kvs {
 		if strings.HasPrefix(k, key) {
 			kvs = append(kvs, &backend.KVPair{
-				Key:   k,
-				Value: v.v,
+				Key:      k,
+				Value:    v.v,
+				Revision: v.rev,
 			})
 		}
 	}
@@ -118,23 +140,38 @@ func (p *Memory) Incr(key string) (int64, error) {
 	}
 	v, _ := strconv.ParseInt(n.v, 10, 64)
 	v++
+	p.rev++
 	n.v = strconv.FormatInt(v, 10)
+	n.rev = p.rev
 	p.kvs[key] = n
 	return v, nil
 }
 
 // Delete the specified key or directory.
 func (p *Memory) Delete(key string, dir bool) error {
+	var deleted []*node
 	p.Lock()
-	defer p.Unlock()
 	if !dir {
-		delete(p.kvs, key)
-		return nil
+		if n, ok := p.kvs[key]; ok {
+			delete(p.kvs, key)
+			deleted = append(deleted, n)
+		}
+	} else {
+		for k, n := range p.kvs {
+			if strings.HasPrefix(k, key) {
+				delete(p.kvs, k)
+				deleted = append(deleted, n)
+			}
+		}
 	}
-	//
-	for k := range p.kvs {
-		if strings.HasPrefix(k, key) {
-			delete(p.kvs, k)
+	p.Unlock()
+	for _, n := range deleted {
+		p.event <- &backend.WatchEvent{
+			Type: backend.Delete,
+			KVPair: backend.KVPair{
+				Key:   n.k,
+				Value: n.v,
+			},
 		}
 	}
 	return nil
@@ -155,12 +192,113 @@ func (p *Memory) Watch(key string, dir bool) (backend.EventChan, error) {
 
 // KeepAlive sets value and updates the ttl for the specified key.
 func (p *Memory) KeepAlive(key, value string, ttl time.Duration) error {
+	// Replace the previous one.
+	if err := p.StopKeepAlive(key); err != nil {
+		return err
+	}
+	p.Lock()
+	p.alive[key] = &alive{}
+	p.Unlock()
+	return p.Set(key, value, 0)
+}
+
+// KeepAliveSlot claims the lowest free slot in [0, n) under the prefix, and writes the key
+// with the value of the slot, both are kept until StopKeepAlive.
+func (p *Memory) KeepAliveSlot(ctx context.Context, key, prefix string, n int64, _ time.Duration,
+	value func(slot int64) string) (*backend.Slot, error) {
+	if err := p.StopKeepAlive(key); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.Lock()
+	for slot := int64(0); slot < n; slot++ {
+		slotKey := prefix + strconv.FormatInt(slot, 10)
+		if _, ok := p.kvs[slotKey]; ok {
+			continue
+		}
+		p.rev++
+		p.kvs[slotKey] = &node{
+			k:      slotKey,
+			v:      key,
+			rev:    p.rev,
+			expire: zeroTime,
+		}
+		s, lost := backend.NewSlot(slot)
+		p.alive[key] = &alive{
+			slot: slotKey,
+			lost: lost,
+		}
+		rev := p.rev
+		p.Unlock()
+		p.event <- &backend.WatchEvent{
+			Type: backend.Put,
+			KVPair: backend.KVPair{
+				Key:      slotKey,
+				Value:    key,
+				Revision: rev,
+			},
+		}
+		return s, p.Set(key, value(slot), 0)
+	}
+	p.Unlock()
+	return nil, backend.ErrSlotsExhausted
+}
+
+// UpdateKeepAlive updates the value of the key kept alive, the ttl is kept.
+func (p *Memory) UpdateKeepAlive(key, value string) error {
+	p.RLock()
+	_, ok := p.alive[key]
+	p.RUnlock()
+	if !ok {
+		return backend.ErrNotKeptAlive
+	}
 	return p.Set(key, value, 0)
 }
 
+// StopKeepAlive stops keeping the key alive and deletes it.
+func (p *Memory) StopKeepAlive(key string) error {
+	p.Lock()
+	a, ok := p.alive[key]
+	delete(p.alive, key)
+	if !ok {
+		p.Unlock()
+		return nil
+	}
+	if a.lost != nil {
+		a.lost()
+	}
+	var deleted []*node
+	for _, k := range []string{key, a.slot} {
+		if n, exist := p.kvs[k]; exist {
+			delete(p.kvs, k)
+			deleted = append(deleted, n)
+		}
+	}
+	p.Unlock()
+	for _, n := range deleted {
+		p.event <- &backend.WatchEvent{
+			Type: backend.Delete,
+			KVPair: backend.KVPair{
+				Key:   n.k,
+				Value: n.v,
+			},
+		}
+	}
+	return nil
+}
+
 // Close the provider connection.
 func (p *Memory) Close() error {
 	p.cancel()
+	p.Lock()
+	for _, a := range p.alive {
+		if a.lost != nil {
+			a.lost()
+		}
+	}
+	p.Unlock()
 	return nil
 }
 
@@ -201,7 +339,11 @@ func (p *Memory) checkWatch() {
 			for _, w := range p.ws {
 				if evt.Key == w.key ||
 					w.prefix && strings.HasPrefix(evt.Key, w.key) {
-					w.ch <- evt
+					select {
+					case w.ch <- evt:
+					case <-w.done:
+					case <-p.ctx.Done():
+					}
 				}
 			}
 			p.RUnlock()
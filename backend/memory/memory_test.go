@@ -0,0 +1,18 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/appootb/grc/backend"
+	"github.com/appootb/grc/backend/backendtest"
+)
+
+func TestMemory(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Provider {
+		p := NewProvider()
+		t.Cleanup(func() {
+			_ = p.Close()
+		})
+		return p
+	})
+}
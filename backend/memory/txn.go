@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/appootb/grc/backend"
+)
+
+// CompareAndSet sets the value if the modification revision of the key equals the revision,
+// the key must not exist if the revision is 0.
+func (p *Memory) CompareAndSet(ctx context.Context, key, value string, revision int64) (bool, error) {
+	return p.Txn(ctx, []backend.Compare{backend.CompareRevision(key, revision)},
+		[]backend.TxnOp{backend.OpPut(key, value)})
+}
+
+// CreateIfNotExists sets the value if the key does not exist.
+func (p *Memory) CreateIfNotExists(ctx context.Context, key, value string) (bool, error) {
+	return p.Txn(ctx, []backend.Compare{backend.CompareAbsent(key)},
+		[]backend.TxnOp{backend.OpPut(key, value)})
+}
+
+// Txn applies the operations atomically if all the comparisons succeed.
+func (p *Memory) Txn(ctx context.Context, cmps []backend.Compare, ops []backend.TxnOp) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	p.Lock()
+	for _, cmp := range cmps {
+		var rev int64
+		if n, ok := p.kvs[cmp.Key]; ok {
+			rev = n.rev
+		}
+		if rev != cmp.Revision {
+			p.Unlock()
+			return false, nil
+		}
+	}
+	p.rev++
+	events := make([]*backend.WatchEvent, 0, len(ops))
+	for _, op := range ops {
+		evt := &backend.WatchEvent{
+			Type: op.Type,
+			KVPair: backend.KVPair{
+				Key:      op.Key,
+				Value:    op.Value,
+				Revision: p.rev,
+			},
+		}
+		if op.Type == backend.Delete {
+			n, ok := p.kvs[op.Key]
+			if !ok {
+				continue
+			}
+			evt.Value = n.v
+			delete(p.kvs, op.Key)
+		} else {
+			p.kvs[op.Key] = &node{
+				k:      op.Key,
+				v:      op.Value,
+				rev:    p.rev,
+				expire: zeroTime,
+			}
+		}
+		events = append(events, evt)
+	}
+	p.Unlock()
+	// Notify the watchers out of the lock.
+	for _, evt := range events {
+		p.event <- evt
+	}
+	return true, nil
+}
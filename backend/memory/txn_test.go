@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appootb/grc/backend"
+)
+
+func TestMemory_Txn(t *testing.T) {
+	p := NewProvider()
+	defer p.Close()
+	txn := p.(backend.Txn)
+	ctx := context.Background()
+
+	ok, err := txn.CreateIfNotExists(ctx, "/test/A", "a")
+	if err != nil || !ok {
+		t.Fatal("actual:", ok, err)
+	}
+	ok, err = txn.CreateIfNotExists(ctx, "/test/A", "b")
+	if err != nil || ok {
+		t.Fatal("actual:", ok, err)
+	}
+	kvs, _ := p.Get("/test/A", false)
+	if len(kvs) != 1 || kvs[0].Value != "a" || kvs[0].Revision == 0 {
+		t.Fatal("actual:", kvs)
+	}
+	revision := kvs[0].Revision
+	// Edited by another operator.
+	if err = p.Set("/test/A", "c", 0); err != nil {
+		t.Fatal(err)
+	}
+	ok, err = txn.CompareAndSet(ctx, "/test/A", "d", revision)
+	if err != nil || ok {
+		t.Fatal("actual:", ok, err)
+	}
+	kvs, _ = p.Get("/test/A", false)
+	ok, err = txn.CompareAndSet(ctx, "/test/A", "d", kvs[0].Revision)
+	if err != nil || !ok {
+		t.Fatal("actual:", ok, err)
+	}
+	// Batch.
+	kvs, _ = p.Get("/test/A", false)
+	ok, err = txn.Txn(ctx, []backend.Compare{
+		backend.CompareRevision("/test/A", kvs[0].Revision),
+		backend.CompareAbsent("/test/B"),
+	}, []backend.TxnOp{
+		backend.OpDelete("/test/A"),
+		backend.OpPut("/test/B", "b"),
+	})
+	if err != nil || !ok {
+		t.Fatal("actual:", ok, err)
+	}
+	kvs, _ = p.Get("/test/", true)
+	if len(kvs) != 1 || kvs[0].Key != "/test/B" {
+		t.Fatal("actual:", kvs)
+	}
+	// Bounded by the ctx.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = txn.CreateIfNotExists(canceled, "/test/C", "c"); err != context.Canceled {
+		t.Fatal("actual:", err)
+	}
+}
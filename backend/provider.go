@@ -5,8 +5,14 @@ import (
 )
 
 const (
-	Memory = "memory" // For debug or test usage.
-	Etcd   = "etcd"
+	Memory     = "memory" // For debug or test usage.
+	Etcd       = "etcd"
+	Consul     = "consul"
+	Zookeeper  = "zookeeper"
+	Redis      = "redis"
+	File       = "file"
+	SQL        = "sql"
+	Kubernetes = "kubernetes"
 )
 
 const (
@@ -22,12 +28,16 @@ type EventType string
 const (
 	Put    EventType = "put"
 	Delete EventType = "delete"
-	Reset  EventType = "reset"
+	// Reset is sent if the changes may be missed, the key is the watched one,
+	// the watchers should reload the keys watched.
+	Reset EventType = "reset"
 )
 
 type KVPair struct {
 	Key   string
 	Value string
+	// Modification revision of the key, 0 if the provider does not support revisions.
+	Revision int64
 }
 
 type KVPairs []*KVPair
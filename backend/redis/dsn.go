@@ -0,0 +1,32 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/appootb/grc/backend"
+)
+
+func init() {
+	backend.Register(backend.Redis, Open)
+}
+
+// Open creates a Redis provider from the DSN,
+// redis://:password@127.0.0.1:6379/base?db=0
+func Open(ctx context.Context, dsn *url.URL) (backend.Provider, string, error) {
+	db := 0
+	if v := dsn.Query().Get("db"); v != "" {
+		var err error
+		if db, err = strconv.Atoi(v); err != nil {
+			return nil, "", fmt.Errorf("grc: invalid db %q", v)
+		}
+	}
+	password, _ := dsn.User.Password()
+	provider, err := NewProvider(ctx, dsn.Host, password, db)
+	if err != nil {
+		return nil, "", err
+	}
+	return provider, backend.BasePath(dsn), nil
+}
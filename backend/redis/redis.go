@@ -0,0 +1,318 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	ScanCount = 1000
+)
+
+var (
+	// Interval of the watch resync, which recovers the missed keyspace notifications.
+	ResyncInterval = time.Second * 30
+)
+
+type Redis struct {
+	db      int
+	watches backend.Watches
+	alive   backend.KeepAlives
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	*redis.Client
+}
+
+func NewProvider(ctx context.Context, addr, password string, db int) (backend.Provider, error) {
+	cli := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     password,
+		DB:           db,
+		DialTimeout:  backend.DialTimeout,
+		ReadTimeout:  backend.ReadTimeout,
+		WriteTimeout: backend.WriteTimeout,
+	})
+	pingCtx, pingCancel := context.WithTimeout(ctx, backend.DialTimeout)
+	defer pingCancel()
+	if err := cli.Ping(pingCtx).Err(); err != nil {
+		_ = cli.Close()
+		return nil, err
+	}
+	// Enable keyspace notifications, may be denied by managed services.
+	if err := cli.ConfigSet(pingCtx, "notify-keyspace-events", "KA").Err(); err != nil {
+		log.Println("grc: redis enable keyspace notifications failed, ", err.Error())
+	}
+	p := &Redis{
+		db:     db,
+		Client: cli,
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	// Only the ttl is refreshed, rewriting the keys notifies the watchers.
+	p.alive.Refresh = p.expire
+	return p, nil
+}
+
+// Type returns the provider type.
+func (p *Redis) Type() string {
+	return backend.Redis
+}
+
+// Set value for the specified key with a specified ttl.
+func (p *Redis) Set(key, value string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	defer cancel()
+	return p.Client.Set(ctx, key, value, ttl).Err()
+}
+
+// Get the value of the specified key or directory.
+func (p *Redis) Get(key string, dir bool) (backend.KVPairs, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.ReadTimeout)
+	defer cancel()
+	if !dir {
+		v, err := p.Client.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return backend.KVPairs{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return backend.KVPairs{
+			{
+				Key:   key,
+				Value: v,
+			},
+		}, nil
+	}
+	//
+	keys, err := p.scan(ctx, key)
+	if err != nil || len(keys) == 0 {
+		return backend.KVPairs{}, err
+	}
+	values, err := p.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	kvs := make(backend.KVPairs, 0, len(keys))
+	for i, v := range values {
+		// Deleted after scanning.
+		if v == nil {
+			continue
+		}
+		kvs = append(kvs, &backend.KVPair{
+			Key:   keys[i],
+			Value: fmt.Sprint(v),
+		})
+	}
+	return kvs, nil
+}
+
+// Incr invokes an atomic value increase for the specified key.
+func (p *Redis) Incr(key string) (int64, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	defer cancel()
+	return p.Client.Incr(ctx, key).Result()
+}
+
+// Delete the specified key or directory.
+func (p *Redis) Delete(key string, dir bool) error {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	defer cancel()
+	if !dir {
+		return p.Del(ctx, key).Err()
+	}
+	//
+	keys, err := p.scan(ctx, key)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	return p.Del(ctx, keys...).Err()
+}
+
+// Watch for changes of the specified key or directory.
+func (p *Redis) Watch(key string, dir bool) (backend.EventChan, error) {
+	pattern := fmt.Sprintf("__keyspace@%d__:%s", p.db, escapePattern(key))
+	if dir {
+		pattern += "*"
+	}
+	pubSub := p.PSubscribe(p.ctx, pattern)
+	ctx, cancel := context.WithTimeout(p.ctx, backend.ReadTimeout)
+	defer cancel()
+	if _, err := pubSub.Receive(ctx); err != nil {
+		_ = pubSub.Close()
+		return nil, err
+	}
+	// Snapshot after subscribed, so no change is lost in between.
+	kvs, err := p.Get(key, dir)
+	if err != nil {
+		_ = pubSub.Close()
+		return nil, err
+	}
+	//
+	watchCtx, eventsChan := p.watches.Add(p.ctx)
+	//
+	p.wg.Add(1)
+	go p.watch(watchCtx, key, dir, pubSub, snapshot(kvs), eventsChan)
+
+	return eventsChan, nil
+}
+
+// Unwatch stops the watch of the channel returned by Watch.
+func (p *Redis) Unwatch(ch backend.EventChan) error {
+	p.watches.Remove(ch)
+	return nil
+}
+
+func (p *Redis) watch(ctx context.Context, key string, dir bool, pubSub *redis.PubSub, last map[string]string, eventsChan backend.EventChan) {
+	defer p.wg.Done()
+	defer p.watches.Remove(eventsChan)
+	ticker := time.NewTicker(ResyncInterval)
+	defer ticker.Stop()
+	defer pubSub.Close()
+
+	prefix := fmt.Sprintf("__keyspace@%d__:", p.db)
+	notifications := pubSub.Channel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case msg, ok := <-notifications:
+			if !ok {
+				return
+			}
+			k := strings.TrimPrefix(msg.Channel, prefix)
+			kvs, err := p.Get(k, false)
+			if err != nil {
+				log.Println("grc: redis watch error, ", err.Error())
+				continue
+			}
+			if len(kvs) == 0 {
+				if v, ok := last[k]; ok {
+					delete(last, k)
+					backend.SendEvent(ctx, eventsChan, newEvent(backend.Delete, k, v))
+				}
+				continue
+			}
+			last[k] = kvs[0].Value
+			backend.SendEvent(ctx, eventsChan, newEvent(backend.Put, k, kvs[0].Value))
+
+		case <-ticker.C:
+			kvs, err := p.Get(key, dir)
+			if err != nil {
+				log.Println("grc: redis watch resync error, ", err.Error())
+				continue
+			}
+			current := snapshot(kvs)
+			if equal(last, current) {
+				continue
+			}
+			// Notifications missed, reset the watcher.
+			last = current
+			backend.SendEvent(ctx, eventsChan, newEvent(backend.Reset, key, ""))
+		}
+	}
+}
+
+// KeepAlive sets value and updates the ttl for the specified key.
+func (p *Redis) KeepAlive(key, value string, ttl time.Duration) error {
+	return p.alive.Start(p.ctx, key, value, ttl, p.Set, func(key string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), backend.WriteTimeout)
+		defer cancel()
+		return p.Del(ctx, key).Err()
+	})
+}
+
+// expire refreshes the ttl of the key, false if the key is missing.
+func (p *Redis) expire(key string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	defer cancel()
+	return p.PExpire(ctx, key, ttl).Result()
+}
+
+// UpdateKeepAlive updates the value of the key kept alive, the ttl is kept.
+func (p *Redis) UpdateKeepAlive(key, value string) error {
+	return p.alive.Update(key, value)
+}
+
+// StopKeepAlive stops keeping the key alive and deletes it.
+func (p *Redis) StopKeepAlive(key string) error {
+	return p.alive.Stop(key)
+}
+
+// Close the provider connection.
+func (p *Redis) Close() error {
+	p.cancel()
+	// Wait for the keys kept alive to be deleted.
+	p.alive.Wait()
+	p.wg.Wait()
+	return p.Client.Close()
+}
+
+func (p *Redis) scan(ctx context.Context, prefix string) ([]string, error) {
+	var (
+		keys   []string
+		cursor uint64
+	)
+	for {
+		batch, next, err := p.Scan(ctx, cursor, escapePattern(prefix)+"*", ScanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+func escapePattern(key string) string {
+	var sb strings.Builder
+	for _, c := range key {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+func snapshot(kvs backend.KVPairs) map[string]string {
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func equal(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func newEvent(typ backend.EventType, key, value string) *backend.WatchEvent {
+	return &backend.WatchEvent{
+		Type: typ,
+		KVPair: backend.KVPair{
+			Key:   key,
+			Value: value,
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/appootb/grc/backend"
+	"github.com/appootb/grc/backend/backendtest"
+)
+
+func newTestProvider(t *testing.T) (*miniredis.Miniredis, backend.Provider) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	p, err := NewProvider(context.Background(), mr.Addr(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+	return mr, p
+}
+
+func TestRedis(t *testing.T) {
+	// miniredis does not emit keyspace notifications, the changes are watched by resyncing.
+	interval := ResyncInterval
+	ResyncInterval = time.Millisecond * 100
+	defer func() {
+		ResyncInterval = interval
+	}()
+	backendtest.Run(t, func(t *testing.T) backend.Provider {
+		_, p := newTestProvider(t)
+		return p
+	})
+}
+
+func TestRedis_TTL(t *testing.T) {
+	mr, p := newTestProvider(t)
+
+	if err := p.Set("/test/a/1", "v1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Set("/test/a/2", "v2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(time.Second)
+	kvs, err := p.Get("/test/a/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || kvs[0].Value != "v1" {
+		t.Fatal("actual:", kvs)
+	}
+}
+
+func TestRedis_WatchNotification(t *testing.T) {
+	mr, p := newTestProvider(t)
+
+	ch, err := p.Watch("/test/watch/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Set("/test/watch/k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	// miniredis does not emit keyspace notifications.
+	mr.Publish("__keyspace@0__:/test/watch/k", "set")
+	evt := backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Put || evt.Key != "/test/watch/k" || evt.Value != "v" {
+		t.Fatal("actual:", evt)
+	}
+	if err = p.Delete("/test/watch/k", false); err != nil {
+		t.Fatal(err)
+	}
+	mr.Publish("__keyspace@0__:/test/watch/k", "del")
+	evt = backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Delete || evt.Key != "/test/watch/k" || evt.Value != "v" {
+		t.Fatal("actual:", evt)
+	}
+}
+
+func TestRedis_WatchResync(t *testing.T) {
+	interval := ResyncInterval
+	ResyncInterval = time.Millisecond * 100
+	defer func() {
+		ResyncInterval = interval
+	}()
+	_, p := newTestProvider(t)
+
+	if err := p.Set("/test/resync/a", "a", 0); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := p.Watch("/test/resync/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Set("/test/resync/b", "b", 0); err != nil {
+		t.Fatal(err)
+	}
+	// A Reset of the watch, not of each key.
+	evt := backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Reset || evt.Key != "/test/resync/" {
+		t.Fatal("actual:", evt)
+	}
+	if err = p.Delete("/test/resync/", true); err != nil {
+		t.Fatal(err)
+	}
+	evt = backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Reset || evt.Key != "/test/resync/" {
+		t.Fatal("actual:", evt)
+	}
+}
+
+func TestRedis_KeepAliveRefresh(t *testing.T) {
+	mr, p := newTestProvider(t)
+
+	if err := p.KeepAlive("/test/alive/node1", "n1", time.Millisecond*300); err != nil {
+		t.Fatal(err)
+	}
+	// Only the ttl is refreshed, the key is not rewritten.
+	mr.Set("/test/alive/node1", "changed")
+	time.Sleep(time.Millisecond * 300)
+	if v, _ := mr.Get("/test/alive/node1"); v != "changed" || mr.TTL("/test/alive/node1") <= 0 {
+		t.Fatal("actual:", v, mr.TTL("/test/alive/node1"))
+	}
+	// Written again if missing.
+	mr.Del("/test/alive/node1")
+	time.Sleep(time.Millisecond * 300)
+	if v, _ := mr.Get("/test/alive/node1"); v != "n1" {
+		t.Fatal("actual:", v)
+	}
+}
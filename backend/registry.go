@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Factory creates a provider from the DSN URL, and returns the base path of the keys.
+type Factory func(ctx context.Context, dsn *url.URL) (Provider, string, error)
+
+var (
+	factoryMu sync.RWMutex
+	factories = map[string]Factory{}
+)
+
+// Register makes a provider available by the URL scheme.
+// It panics if Register is called twice for the same scheme or if factory is nil.
+func Register(scheme string, factory Factory) {
+	factoryMu.Lock()
+	defer factoryMu.Unlock()
+	if factory == nil {
+		panic("grc: Register provider factory is nil")
+	}
+	if _, dup := factories[scheme]; dup {
+		panic("grc: Register called twice for provider " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// Schemes returns a sorted list of the registered schemes.
+func Schemes() []string {
+	factoryMu.RLock()
+	defer factoryMu.RUnlock()
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open creates a provider from the DSN, such as etcd://user:pass@h1:2379,h2:2379/base,
+// and returns the base path of the keys.
+func Open(ctx context.Context, dsn string) (Provider, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, "", err
+	}
+	factoryMu.RLock()
+	factory, ok := factories[u.Scheme]
+	factoryMu.RUnlock()
+	if !ok {
+		return nil, "", fmt.Errorf("grc: unknown provider %q (forgotten import?)", u.Scheme)
+	}
+	return factory(ctx, u)
+}
+
+// Hosts returns the comma separated hosts of the URL.
+func Hosts(dsn *url.URL) []string {
+	if dsn.Host == "" {
+		return nil
+	}
+	return strings.Split(dsn.Host, ",")
+}
+
+// BasePath returns the path of the URL, or the base query parameter if set.
+func BasePath(dsn *url.URL) string {
+	if base := dsn.Query().Get("base"); base != "" {
+		return base
+	}
+	return dsn.Path
+}
+
+// Duration parses the duration query parameter of the URL.
+func Duration(dsn *url.URL, name string, defaultValue time.Duration) (time.Duration, error) {
+	value := dsn.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("grc: invalid %s %q", name, value)
+	}
+	return d, nil
+}
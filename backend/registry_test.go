@@ -0,0 +1,31 @@
+package backend
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegistry_URL(t *testing.T) {
+	u, err := url.Parse("etcd://user:pass@h1:2379,h2:2379/base?dial_timeout=5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hosts := Hosts(u); !reflect.DeepEqual(hosts, []string{"h1:2379", "h2:2379"}) {
+		t.Fatal("actual:", hosts)
+	}
+	if path := BasePath(u); path != "/base" {
+		t.Fatal("actual:", path)
+	}
+	if d, err := Duration(u, "dial_timeout", DialTimeout); err != nil || d != time.Second*5 {
+		t.Fatal("actual:", d, err)
+	}
+	if d, err := Duration(u, "read_timeout", ReadTimeout); err != nil || d != ReadTimeout {
+		t.Fatal("actual:", d, err)
+	}
+	u, _ = url.Parse("file:///var/lib/grc?base=/base")
+	if path := BasePath(u); path != "/base" {
+		t.Fatal("actual:", path)
+	}
+}
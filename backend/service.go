@@ -5,9 +5,14 @@ import (
 )
 
 const (
-	ServicePrefix    = "service"
-	ServiceOpsPrefix = "ops"
-	ServiceNodeIDKey = "node_id"
+	ServicePrefix         = "service"
+	ServiceOpsPrefix      = "ops"
+	ServiceNodeIDKey      = "node_id"
+	ServiceWorkerIDPrefix = "worker_id"
+	ServiceWorkerPrefix   = "worker"
+	ServiceNodeSlotPrefix = "node_slot"
+	ServiceElectionPrefix = "election"
+	ServiceLockPrefix     = "lock"
 )
 
 func ServiceDiscoveryPrefixKey(path string) string {
@@ -25,3 +30,27 @@ func ServiceOpsKey(path, service, node string) string {
 func ServiceNodeIDIncrKey(path, service string) string {
 	return fmt.Sprintf("%s/%s/%s", path, ServiceNodeIDKey, service)
 }
+
+func ServiceWorkerIDPrefixKey(path, service string) string {
+	return fmt.Sprintf("%s/%s/%s/", path, ServiceWorkerIDPrefix, service)
+}
+
+func ServiceWorkerIDKey(path, service string, id int64) string {
+	return fmt.Sprintf("%s/%s/%s/%d", path, ServiceWorkerIDPrefix, service, id)
+}
+
+func ServiceWorkerKey(path, service, node string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", path, ServiceWorkerPrefix, service, node)
+}
+
+func ServiceNodeSlotPrefixKey(path, service string) string {
+	return fmt.Sprintf("%s/%s/%s/", path, ServiceNodeSlotPrefix, service)
+}
+
+func ServiceElectionKey(path, service string) string {
+	return fmt.Sprintf("%s/%s/%s", path, ServiceElectionPrefix, service)
+}
+
+func ServiceLockKey(path, name string) string {
+	return fmt.Sprintf("%s/%s/%s", path, ServiceLockPrefix, name)
+}
@@ -0,0 +1,27 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/appootb/grc/backend"
+)
+
+func init() {
+	backend.Register(backend.SQL, Open)
+}
+
+// Open creates an SQL provider from the DSN, the driver must be registered,
+// sql:///base?driver=sqlite3&dsn=file:grc.db
+func Open(ctx context.Context, dsn *url.URL) (backend.Provider, string, error) {
+	query := dsn.Query()
+	if query.Get("driver") == "" {
+		return nil, "", fmt.Errorf("grc: sql driver required")
+	}
+	provider, err := NewProvider(ctx, query.Get("driver"), query.Get("dsn"))
+	if err != nil {
+		return nil, "", err
+	}
+	return provider, backend.BasePath(dsn), nil
+}
@@ -0,0 +1,403 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/appootb/grc/backend"
+)
+
+const (
+	// Number of revisions the delete tombstones are kept for lagging watchers.
+	CompactRetention = 1000
+)
+
+var (
+	// Interval of the watch polling and the ttl sweeping.
+	PollInterval = time.Second
+)
+
+// Rollback the transaction without an error.
+var errNoChange = errors.New("grc: no change")
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS grc_kv (
+		k        VARCHAR(1024) PRIMARY KEY,
+		v        TEXT NOT NULL,
+		revision BIGINT NOT NULL,
+		expire   BIGINT NOT NULL,
+		deleted  SMALLINT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS grc_kv_revision ON grc_kv (revision)`,
+	`CREATE TABLE IF NOT EXISTS grc_meta (
+		name  VARCHAR(64) PRIMARY KEY,
+		value BIGINT NOT NULL
+	)`,
+	`INSERT INTO grc_meta (name, value) VALUES ('revision', 0), ('compact', 0) ON CONFLICT (name) DO NOTHING`,
+}
+
+// SQL stores the keys as rows with a monotonic revision, every write bumps
+// the single revision row first so revisions are committed in order.
+type SQL struct {
+	db       *sql.DB
+	interval time.Duration
+	watches  backend.Watches
+	alive    backend.KeepAlives
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewProvider opens the database with a registered driver, such as sqlite3 or postgres.
+func NewProvider(ctx context.Context, driver, dsn string) (backend.Provider, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	initCtx, cancel := context.WithTimeout(ctx, backend.DialTimeout)
+	defer cancel()
+	for _, stmt := range schema {
+		if _, err = db.ExecContext(initCtx, stmt); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	p := &SQL{
+		db:       db,
+		interval: PollInterval,
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	// Only the ttl is refreshed, a new revision notifies the watchers.
+	p.alive.Refresh = p.expire
+	go p.checkTTL()
+	return p, nil
+}
+
+// Type returns the provider type.
+func (p *SQL) Type() string {
+	return backend.SQL
+}
+
+// Set value for the specified key with a specified ttl.
+func (p *SQL) Set(key, value string, ttl time.Duration) error {
+	expire := int64(0)
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+	return p.update(p.ctx, func(ctx context.Context, tx *sql.Tx, revision int64) error {
+		return put(ctx, tx, key, value, revision, expire)
+	})
+}
+
+// Get the value of the specified key or directory.
+func (p *SQL) Get(key string, dir bool) (backend.KVPairs, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.ReadTimeout)
+	defer cancel()
+	where, args := match(key, dir, 2)
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT k, v FROM grc_kv WHERE deleted = 0 AND (expire = 0 OR expire > $1) AND `+where,
+		append([]interface{}{time.Now().UnixNano()}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	kvs := backend.KVPairs{}
+	for rows.Next() {
+		var kv backend.KVPair
+		if err = rows.Scan(&kv.Key, &kv.Value); err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, &kv)
+	}
+	return kvs, rows.Err()
+}
+
+// Incr invokes an atomic value increase for the specified key.
+func (p *SQL) Incr(key string) (int64, error) {
+	var num int64
+	err := p.update(p.ctx, func(ctx context.Context, tx *sql.Tx, revision int64) error {
+		var value string
+		err := tx.QueryRowContext(ctx,
+			`SELECT v FROM grc_kv WHERE k = $1 AND deleted = 0 AND (expire = 0 OR expire > $2)`,
+			key, time.Now().UnixNano()).Scan(&value)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		num, _ = strconv.ParseInt(value, 10, 64)
+		num++
+		return put(ctx, tx, key, strconv.FormatInt(num, 10), revision, 0)
+	})
+	return num, err
+}
+
+// Delete the specified key or directory.
+func (p *SQL) Delete(key string, dir bool) error {
+	return p.delete(p.ctx, key, dir)
+}
+
+func (p *SQL) delete(ctx context.Context, key string, dir bool) error {
+	return p.update(ctx, func(ctx context.Context, tx *sql.Tx, revision int64) error {
+		where, args := match(key, dir, 2)
+		_, err := tx.ExecContext(ctx,
+			`UPDATE grc_kv SET deleted = 1, revision = $1 WHERE deleted = 0 AND `+where,
+			append([]interface{}{revision}, args...)...)
+		return err
+	})
+}
+
+// Watch for changes of the specified key or directory.
+func (p *SQL) Watch(key string, dir bool) (backend.EventChan, error) {
+	revision, _, err := p.revision()
+	if err != nil {
+		return nil, err
+	}
+	//
+	ctx, eventsChan := p.watches.Add(p.ctx)
+	//
+	go p.watch(ctx, key, dir, revision, eventsChan)
+
+	return eventsChan, nil
+}
+
+// Unwatch stops the watch of the channel returned by Watch.
+func (p *SQL) Unwatch(ch backend.EventChan) error {
+	p.watches.Remove(ch)
+	return nil
+}
+
+func (p *SQL) watch(ctx context.Context, key string, dir bool, revision int64, eventsChan backend.EventChan) {
+	defer p.watches.Remove(eventsChan)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			current, compact, err := p.revision()
+			if err != nil {
+				log.Println("grc: sql watch error, ", err.Error())
+				continue
+			}
+			if compact > revision {
+				log.Println("grc: sql revision compacted")
+				if !backend.SendEvent(ctx, eventsChan, newEvent(backend.Reset, key, "")) {
+					return
+				}
+				revision = current
+				continue
+			}
+			if revision, err = p.changes(ctx, key, dir, revision, current, eventsChan); err != nil && ctx.Err() == nil {
+				log.Println("grc: sql watch error, ", err.Error())
+			}
+		}
+	}
+}
+
+// changes emits the rows updated in (revision, current], returns the current revision scanned,
+// or the last revision seen on error. The revisions are committed in order, none is missed.
+func (p *SQL) changes(ctx context.Context, key string, dir bool, revision, current int64, eventsChan backend.EventChan) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, backend.ReadTimeout)
+	defer cancel()
+	where, args := match(key, dir, 3)
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT k, v, revision, deleted FROM grc_kv WHERE revision > $1 AND revision <= $2 AND `+where+` ORDER BY revision`,
+		append([]interface{}{revision, current}, args...)...)
+	if err != nil {
+		return revision, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			kv      backend.KVPair
+			deleted int
+		)
+		if err = rows.Scan(&kv.Key, &kv.Value, &revision, &deleted); err != nil {
+			return revision, err
+		}
+		evt := newEvent(backend.Put, kv.Key, kv.Value)
+		if deleted != 0 {
+			evt.Type = backend.Delete
+		}
+		if !backend.SendEvent(ctx, eventsChan, evt) {
+			return revision, ctx.Err()
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return revision, err
+	}
+	return current, nil
+}
+
+// expire refreshes the ttl of the row without a new revision, false if the row is missing.
+func (p *SQL) expire(key string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.WriteTimeout)
+	defer cancel()
+	now := time.Now()
+	res, err := p.db.ExecContext(ctx,
+		`UPDATE grc_kv SET expire = $1 WHERE k = $2 AND deleted = 0 AND expire > $3`,
+		now.Add(ttl).UnixNano(), key, now.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// KeepAlive sets value and updates the ttl for the specified key.
+func (p *SQL) KeepAlive(key, value string, ttl time.Duration) error {
+	return p.alive.Start(p.ctx, key, value, ttl, p.Set, func(key string) error {
+		return p.delete(context.Background(), key, false)
+	})
+}
+
+// UpdateKeepAlive updates the value of the key kept alive, the ttl is kept.
+func (p *SQL) UpdateKeepAlive(key, value string) error {
+	return p.alive.Update(key, value)
+}
+
+// StopKeepAlive stops keeping the key alive and deletes it.
+func (p *SQL) StopKeepAlive(key string) error {
+	return p.alive.Stop(key)
+}
+
+// Close the provider connection.
+func (p *SQL) Close() error {
+	p.cancel()
+	// Wait for the keys kept alive to be deleted.
+	p.alive.Wait()
+	return p.db.Close()
+}
+
+func (p *SQL) checkTTL() {
+	ticker := time.NewTicker(p.interval)
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			ticker.Stop()
+			return
+		case <-ticker.C:
+			err := p.update(p.ctx, func(ctx context.Context, tx *sql.Tx, revision int64) error {
+				// Expired rows become tombstones, so the watchers are notified.
+				res, err := tx.ExecContext(ctx,
+					`UPDATE grc_kv SET deleted = 1, revision = $1 WHERE deleted = 0 AND expire > 0 AND expire < $2`,
+					revision, time.Now().UnixNano())
+				if err != nil {
+					return err
+				}
+				expired, _ := res.RowsAffected()
+				// Compact the old tombstones.
+				compact := revision - CompactRetention
+				if compact > 0 {
+					res, err = tx.ExecContext(ctx,
+						`DELETE FROM grc_kv WHERE deleted = 1 AND revision <= $1`, compact)
+					if err != nil {
+						return err
+					}
+					if n, _ := res.RowsAffected(); n > 0 {
+						_, err = tx.ExecContext(ctx,
+							`UPDATE grc_meta SET value = $1 WHERE name = 'compact'`, compact)
+						return err
+					}
+				}
+				if expired == 0 {
+					return errNoChange
+				}
+				return nil
+			})
+			if err != nil && p.ctx.Err() == nil {
+				log.Println("grc: sql checkTTL failed, ", err.Error())
+			}
+		}
+	}
+}
+
+// update runs fn in a transaction with a new revision.
+func (p *SQL) update(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx, revision int64) error) error {
+	ctx, cancel := context.WithTimeout(ctx, backend.WriteTimeout)
+	defer cancel()
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var revision int64
+	// Lock the revision row, which serializes the writers.
+	_, err = tx.ExecContext(ctx, `UPDATE grc_meta SET value = value + 1 WHERE name = 'revision'`)
+	if err == nil {
+		err = tx.QueryRowContext(ctx, `SELECT value FROM grc_meta WHERE name = 'revision'`).Scan(&revision)
+	}
+	if err == nil {
+		err = fn(ctx, tx, revision)
+	}
+	if err == errNoChange {
+		return tx.Rollback()
+	} else if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *SQL) revision() (int64, int64, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, backend.ReadTimeout)
+	defer cancel()
+	rows, err := p.db.QueryContext(ctx, `SELECT name, value FROM grc_meta`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	var revision, compact int64
+	for rows.Next() {
+		var (
+			name  string
+			value int64
+		)
+		if err = rows.Scan(&name, &value); err != nil {
+			return 0, 0, err
+		}
+		switch name {
+		case "revision":
+			revision = value
+		case "compact":
+			compact = value
+		}
+	}
+	return revision, compact, rows.Err()
+}
+
+func put(ctx context.Context, tx *sql.Tx, key, value string, revision, expire int64) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO grc_kv (k, v, revision, expire, deleted) VALUES ($1, $2, $3, $4, 0)
+		ON CONFLICT (k) DO UPDATE SET v = excluded.v, revision = excluded.revision, expire = excluded.expire, deleted = 0`,
+		key, value, revision, expire)
+	return err
+}
+
+// match returns the key condition, with placeholders numbered from n.
+func match(key string, dir bool, n int) (string, []interface{}) {
+	if !dir {
+		return "k = $" + strconv.Itoa(n), []interface{}{key}
+	}
+	// LIKE is case insensitive in SQLite, compare the prefix instead.
+	// SQLite numbers the parameters in order of appearance.
+	return "$" + strconv.Itoa(n) + " = substr(k, 1, $" + strconv.Itoa(n+1) + ")",
+		[]interface{}{key, utf8.RuneCountInString(key)}
+}
+
+func newEvent(typ backend.EventType, key, value string) *backend.WatchEvent {
+	return &backend.WatchEvent{
+		Type: typ,
+		KVPair: backend.KVPair{
+			Key:   key,
+			Value: value,
+		},
+	}
+}
@@ -0,0 +1,183 @@
+package sql
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"github.com/appootb/grc/backend/backendtest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestProvider(t *testing.T) backend.Provider {
+	interval := PollInterval
+	PollInterval = time.Millisecond * 50
+	t.Cleanup(func() {
+		PollInterval = interval
+	})
+	dir, err := ioutil.TempDir("", "grc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return openTestProvider(t, filepath.Join(dir, "grc.db"))
+}
+
+func openTestProvider(t *testing.T, name string) *SQL {
+	dsn := "file:" + name + "?_busy_timeout=5000&_txlock=immediate&_journal_mode=WAL"
+	p, err := NewProvider(context.Background(), "sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+	return p.(*SQL)
+}
+
+func TestSQL(t *testing.T) {
+	backendtest.Run(t, newTestProvider)
+}
+
+// LIKE is case insensitive in SQLite, the prefixes must be matched exactly.
+func TestSQL_Prefix(t *testing.T) {
+	p := newTestProvider(t)
+
+	for k, v := range map[string]string{
+		"/test/config/svc/A":  "a",
+		"/test/config/svc/a":  "b",
+		"/test/config/SVC/A":  "c",
+		"/test/config/svc2/A": "d",
+	} {
+		if err := p.Set(k, v, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kvs, err := p.Get("/test/config/svc/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(kvs, func(i, j int) bool {
+		return kvs[i].Key < kvs[j].Key
+	})
+	if len(kvs) != 2 || kvs[0].Value != "a" || kvs[1].Value != "b" {
+		t.Fatal("actual:", kvs)
+	}
+	if err = p.Delete("/test/config/svc/", true); err != nil {
+		t.Fatal(err)
+	}
+	kvs, err = p.Get("/test/config/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 2 {
+		t.Fatal("actual:", kvs)
+	}
+}
+
+func TestSQL_TTL(t *testing.T) {
+	p := newTestProvider(t)
+
+	ch, err := p.Watch("/test/service/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Set("/test/service/svc/node2", "n2", time.Millisecond*100); err != nil {
+		t.Fatal(err)
+	}
+	evt := backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Put || evt.Key != "/test/service/svc/node2" {
+		t.Fatal("actual:", evt)
+	}
+	// Expired by checkTTL.
+	evt = backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Delete || evt.Key != "/test/service/svc/node2" {
+		t.Fatal("actual:", evt)
+	}
+}
+
+// The rows kept alive are deleted on Close, without waiting for the ttl.
+func TestSQL_KeepAlive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "grc.db")
+
+	p := openTestProvider(t, name)
+	if err = p.KeepAlive("/test/service/svc/node1", "n1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p = openTestProvider(t, name)
+	kvs, err := p.Get("/test/service/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 0 {
+		t.Fatal("actual:", kvs)
+	}
+}
+
+func TestSQL_KeepAliveRefresh(t *testing.T) {
+	p := newTestProvider(t)
+
+	ch, err := p.Watch("/test/service/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.KeepAlive("/test/service/svc/node1", "n1", time.Millisecond*300); err != nil {
+		t.Fatal(err)
+	}
+	evt := backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Put || evt.Key != "/test/service/svc/node1" {
+		t.Fatal("actual:", evt)
+	}
+	// The refreshes are not changes.
+	select {
+	case evt = <-ch:
+		t.Fatal("actual:", evt)
+	case <-time.After(time.Millisecond * 600):
+	}
+	kvs, err := p.Get("/test/service/svc/node1", false)
+	if err != nil || len(kvs) != 1 {
+		t.Fatal("actual:", kvs, err)
+	}
+}
+
+// The watchers advance to the revision scanned, the changes of the other keys are not replayed.
+func TestSQL_WatchCompacted(t *testing.T) {
+	p := newTestProvider(t).(*SQL)
+
+	ch, err := p.Watch("/test/watch/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err = p.Set("/test/other/A", "a", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(p.interval * 3)
+	// Compacted up to the revisions scanned.
+	if _, err = p.db.Exec(`UPDATE grc_meta SET value = (SELECT value FROM grc_meta WHERE name = 'revision') WHERE name = 'compact'`); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Set("/test/watch/A", "a", 0); err != nil {
+		t.Fatal(err)
+	}
+	evt := backendtest.WaitEvent(t, ch)
+	if evt.Type != backend.Put || evt.Key != "/test/watch/A" {
+		t.Fatal("actual:", evt)
+	}
+}
@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"context"
+)
+
+// Compare is a condition of a transaction.
+type Compare struct {
+	Key string
+	// The key must not exist if the revision is 0.
+	Revision int64
+}
+
+// CompareRevision succeeds if the modification revision of the key equals the revision.
+func CompareRevision(key string, revision int64) Compare {
+	return Compare{
+		Key:      key,
+		Revision: revision,
+	}
+}
+
+// CompareAbsent succeeds if the key does not exist.
+func CompareAbsent(key string) Compare {
+	return Compare{
+		Key: key,
+	}
+}
+
+// TxnOp is an operation of a transaction, Put or Delete.
+type TxnOp struct {
+	Type  EventType
+	Key   string
+	Value string
+}
+
+// OpPut sets the value of the key.
+func OpPut(key, value string) TxnOp {
+	return TxnOp{
+		Type:  Put,
+		Key:   key,
+		Value: value,
+	}
+}
+
+// OpDelete deletes the key.
+func OpDelete(key string) TxnOp {
+	return TxnOp{
+		Type: Delete,
+		Key:  key,
+	}
+}
+
+// Txn is implemented by the providers which support atomic updates, each call is bounded by the ctx.
+type Txn interface {
+	// CompareAndSet sets the value if the modification revision of the key equals the revision,
+	// the key must not exist if the revision is 0.
+	CompareAndSet(ctx context.Context, key, value string, revision int64) (bool, error)
+
+	// CreateIfNotExists sets the value if the key does not exist.
+	CreateIfNotExists(ctx context.Context, key, value string) (bool, error)
+
+	// Txn applies the operations atomically if all the comparisons succeed.
+	Txn(ctx context.Context, cmps []Compare, ops []TxnOp) (bool, error)
+}
@@ -0,0 +1,92 @@
+package backend
+
+import (
+	"context"
+	"sync"
+)
+
+// Unwatcher is implemented by the providers which can stop a watch before the provider is closed.
+type Unwatcher interface {
+	// Unwatch stops the watch of the channel returned by Watch, the channel is not closed.
+	Unwatch(ch EventChan) error
+}
+
+// Watches tracks the watches of a provider, each watch runs until its context is done.
+type Watches struct {
+	mu      sync.Mutex
+	cancels map[EventChan]context.CancelFunc
+}
+
+// Add returns the channel of a new watch, and the context of the watch,
+// which is done when the ctx is done or the watch is removed.
+func (ws *Watches) Add(ctx context.Context) (context.Context, EventChan) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.cancels == nil {
+		ws.cancels = make(map[EventChan]context.CancelFunc)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	ch := make(EventChan, DefaultChanLen)
+	ws.cancels[ch] = cancel
+	return ctx, ch
+}
+
+// Remove stops the watch of the channel, it is a no-op if the watch was removed.
+func (ws *Watches) Remove(ch EventChan) {
+	ws.mu.Lock()
+	cancel, ok := ws.cancels[ch]
+	delete(ws.cancels, ch)
+	ws.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// SendEvent sends the event to the channel, false if the ctx is done first.
+func SendEvent(ctx context.Context, ch EventChan, evt *WatchEvent) bool {
+	// Select picks randomly if both are ready.
+	if ctx.Err() != nil {
+		return false
+	}
+	select {
+	case ch <- evt:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Watcher is the handle of a watch, Close stops it.
+type Watcher struct {
+	ch     EventChan
+	cancel context.CancelFunc
+}
+
+// NewWatcher watches for changes of the specified key, or directory WithPrefix,
+// until the ctx is done or the watcher is closed.
+func NewWatcher(ctx context.Context, cp ContextProvider, key string, opts ...OpOption) (*Watcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	ch, err := cp.Watch(ctx, key, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Watcher{
+		ch:     ch,
+		cancel: cancel,
+	}, nil
+}
+
+// Events returns the channel of the watch events, which is closed when the watch stops.
+func (w *Watcher) Events() EventChan {
+	return w.ch
+}
+
+// Close stops the watch and waits until the channel is closed,
+// the pending events are discarded.
+func (w *Watcher) Close() error {
+	w.cancel()
+	for range w.ch {
+	}
+	return nil
+}
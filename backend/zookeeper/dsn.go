@@ -0,0 +1,23 @@
+package zookeeper
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/appootb/grc/backend"
+)
+
+func init() {
+	backend.Register(backend.Zookeeper, Open)
+}
+
+// Open creates a ZooKeeper provider from the DSN,
+// zookeeper://user:pass@h1:2181,h2:2181/base
+func Open(ctx context.Context, dsn *url.URL) (backend.Provider, string, error) {
+	password, _ := dsn.User.Password()
+	provider, err := NewProvider(ctx, backend.Hosts(dsn), dsn.User.Username(), password)
+	if err != nil {
+		return nil, "", err
+	}
+	return provider, backend.BasePath(dsn), nil
+}
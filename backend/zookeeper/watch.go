@@ -0,0 +1,205 @@
+package zookeeper
+
+import (
+	"context"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"github.com/go-zookeeper/zk"
+)
+
+type entry struct {
+	value string
+	mzxid int64
+}
+
+// ZooKeeper watches are one-shot, the watcher re-arms the fired ones
+// and diffs the subtree against the last snapshot.
+type watcher struct {
+	p   *Zookeeper
+	ctx context.Context
+	key string
+	dir bool
+
+	kvs      map[string]entry
+	data     map[string]bool
+	children map[string]bool
+
+	fired  chan zk.Event
+	reset  chan struct{}
+	events backend.EventChan
+}
+
+func (w *watcher) watch() {
+	defer func() {
+		w.p.Lock()
+		delete(w.p.watchers, w)
+		w.p.Unlock()
+		w.p.watches.Remove(w.events)
+	}()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+
+		case evt := <-w.fired:
+			w.disarm(evt)
+			w.update(backend.Put)
+
+		case <-w.reset:
+			w.data = make(map[string]bool)
+			w.children = make(map[string]bool)
+			w.update(backend.Reset)
+		}
+	}
+}
+
+func (w *watcher) disarm(evt zk.Event) {
+	switch evt.Type {
+	case zk.EventNotWatching:
+		w.data = make(map[string]bool)
+		w.children = make(map[string]bool)
+	case zk.EventNodeChildrenChanged:
+		w.children[evt.Path] = false
+	case zk.EventNodeDeleted:
+		w.data[evt.Path] = false
+		w.children[evt.Path] = false
+	default:
+		w.data[evt.Path] = false
+	}
+}
+
+func (w *watcher) update(typ backend.EventType) {
+	for {
+		kvs, err := w.sync()
+		if err == nil {
+			w.notify(kvs, typ)
+			return
+		}
+		if w.ctx.Err() != nil {
+			return
+		}
+		log.Println("grc: zookeeper watch error, ", err.Error())
+		time.Sleep(backend.RetryTimeout)
+	}
+}
+
+func (w *watcher) notify(kvs map[string]entry, typ backend.EventType) {
+	if typ == backend.Reset {
+		w.kvs = kvs
+		backend.SendEvent(w.ctx, w.events, newEvent(backend.Reset, w.key, ""))
+		return
+	}
+	for k, v := range kvs {
+		if prev, ok := w.kvs[k]; ok && prev.mzxid == v.mzxid {
+			continue
+		}
+		if !backend.SendEvent(w.ctx, w.events, newEvent(typ, k, v.value)) {
+			return
+		}
+	}
+	for k, v := range w.kvs {
+		if _, ok := kvs[k]; ok {
+			continue
+		}
+		if !backend.SendEvent(w.ctx, w.events, newEvent(backend.Delete, k, v.value)) {
+			return
+		}
+	}
+	w.kvs = kvs
+}
+
+// sync reads the watched znodes and arms the missing watches.
+func (w *watcher) sync() (map[string]entry, error) {
+	conn := w.p.conn
+	kvs := make(map[string]entry)
+	root := nodePath(w.key)
+
+	var visit func(zkPath string) error
+	visit = func(zkPath string) error {
+		if !w.data[zkPath] {
+			_, _, ch, err := conn.ExistsW(zkPath)
+			if err != nil {
+				return err
+			}
+			w.arm(w.data, zkPath, ch)
+		}
+		data, stat, err := conn.Get(zkPath)
+		if err == zk.ErrNoNode {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !w.dir {
+			kvs[w.key] = entry{
+				value: string(data),
+				mzxid: stat.Mzxid,
+			}
+			return nil
+		}
+		//
+		var children []string
+		if !w.children[zkPath] {
+			var ch <-chan zk.Event
+			children, _, ch, err = conn.ChildrenW(zkPath)
+			if err == nil {
+				w.arm(w.children, zkPath, ch)
+			}
+		} else {
+			children, _, err = conn.Children(zkPath)
+		}
+		if err == zk.ErrNoNode {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// Znodes without a value are directories.
+		if len(data) > 0 && !(zkPath == root && strings.HasSuffix(w.key, "/")) {
+			kvs[grcKey(w.key, zkPath)] = entry{
+				value: string(data),
+				mzxid: stat.Mzxid,
+			}
+		}
+		for _, child := range children {
+			if err = visit(path.Join(zkPath, child)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return kvs, visit(root)
+}
+
+func (w *watcher) arm(armed map[string]bool, zkPath string, ch <-chan zk.Event) {
+	armed[zkPath] = true
+	go func() {
+		var evt zk.Event
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			evt = e
+		case <-w.ctx.Done():
+			return
+		}
+		select {
+		case w.fired <- evt:
+		case <-w.ctx.Done():
+		}
+	}()
+}
+
+func newEvent(typ backend.EventType, key, value string) *backend.WatchEvent {
+	return &backend.WatchEvent{
+		Type: typ,
+		KVPair: backend.KVPair{
+			Key:   key,
+			Value: value,
+		},
+	}
+}
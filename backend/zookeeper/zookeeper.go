@@ -0,0 +1,456 @@
+package zookeeper
+
+import (
+	"context"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"github.com/go-zookeeper/zk"
+)
+
+const (
+	SessionTimeout = time.Second * 10
+)
+
+// Conn is the subset of *zk.Conn used by the provider.
+type Conn interface {
+	SessionID() int64
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Delete(path string, version int32) error
+	Exists(path string) (bool, *zk.Stat, error)
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	Children(path string) ([]string, *zk.Stat, error)
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	Close()
+}
+
+type Zookeeper struct {
+	conn Conn
+	acl  []zk.ACL
+
+	alive    map[string]string
+	watchers map[*watcher]struct{}
+	watches  backend.Watches
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	sync.Mutex
+}
+
+func NewProvider(ctx context.Context, servers []string, username, password string) (backend.Provider, error) {
+	conn, events, err := zk.Connect(servers, SessionTimeout, zk.WithLogInfo(false))
+	if err != nil {
+		return nil, err
+	}
+	acl := zk.WorldACL(zk.PermAll)
+	if username != "" {
+		if err = conn.AddAuth("digest", []byte(username+":"+password)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		acl = zk.DigestACL(zk.PermAll, username, password)
+	}
+	return newProvider(ctx, conn, events, acl), nil
+}
+
+func newProvider(ctx context.Context, conn Conn, events <-chan zk.Event, acl []zk.ACL) *Zookeeper {
+	p := &Zookeeper{
+		conn:     conn,
+		acl:      acl,
+		alive:    make(map[string]string),
+		watchers: make(map[*watcher]struct{}),
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	go p.checkSession(events)
+	return p
+}
+
+// Type returns the provider type.
+func (p *Zookeeper) Type() string {
+	return backend.Zookeeper
+}
+
+// Set value for the specified key with a specified ttl.
+// Keys with ttl > 0 are ephemeral znodes, which live as long as the session.
+func (p *Zookeeper) Set(key, value string, ttl time.Duration) error {
+	if ttl > 0 {
+		return p.setEphemeral(nodePath(key), value)
+	}
+	return p.setPersistent(nodePath(key), value)
+}
+
+// Get the value of the specified key or directory.
+func (p *Zookeeper) Get(key string, dir bool) (backend.KVPairs, error) {
+	if !dir {
+		data, _, err := p.conn.Get(nodePath(key))
+		if err == zk.ErrNoNode {
+			return backend.KVPairs{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return backend.KVPairs{
+			{
+				Key:   key,
+				Value: string(data),
+			},
+		}, nil
+	}
+	//
+	var kvs backend.KVPairs
+	err := p.walk(key, func(zkPath string, data []byte) error {
+		kvs = append(kvs, &backend.KVPair{
+			Key:   grcKey(key, zkPath),
+			Value: string(data),
+		})
+		return nil
+	})
+	return kvs, err
+}
+
+// Incr invokes an atomic value increase for the specified key.
+func (p *Zookeeper) Incr(key string) (int64, error) {
+	zkPath := nodePath(key)
+	for {
+		select {
+		case <-p.ctx.Done():
+			return 0, p.ctx.Err()
+		default:
+		}
+
+		data, stat, err := p.conn.Get(zkPath)
+		if err == zk.ErrNoNode {
+			if err = p.createParents(zkPath); err != nil {
+				return 0, err
+			}
+			_, err = p.conn.Create(zkPath, []byte("1"), 0, p.acl)
+			if err == zk.ErrNodeExists {
+				continue
+			} else if err != nil {
+				return 0, err
+			}
+			return 1, nil
+		} else if err != nil {
+			return 0, err
+		}
+		num, _ := strconv.ParseInt(string(data), 10, 64)
+		num++
+		_, err = p.conn.Set(zkPath, []byte(strconv.FormatInt(num, 10)), stat.Version)
+		if err == zk.ErrBadVersion {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		return num, nil
+	}
+}
+
+// Delete the specified key or directory.
+func (p *Zookeeper) Delete(key string, dir bool) error {
+	if !dir {
+		err := p.conn.Delete(nodePath(key), -1)
+		if err == zk.ErrNotEmpty {
+			// Keep the children, clear the value only.
+			_, err = p.conn.Set(nodePath(key), nil, -1)
+		}
+		if err == zk.ErrNoNode {
+			return nil
+		}
+		return err
+	}
+	//
+	root := nodePath(key)
+	children, _, err := p.conn.Children(root)
+	if err == zk.ErrNoNode {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err = p.deleteTree(path.Join(root, child)); err != nil {
+			return err
+		}
+	}
+	if !strings.HasSuffix(key, "/") {
+		return p.deleteTree(root)
+	}
+	return nil
+}
+
+// Watch for changes of the specified key or directory.
+func (p *Zookeeper) Watch(key string, dir bool) (backend.EventChan, error) {
+	ctx, events := p.watches.Add(p.ctx)
+	w := &watcher{
+		p:        p,
+		ctx:      ctx,
+		key:      key,
+		dir:      dir,
+		data:     make(map[string]bool),
+		children: make(map[string]bool),
+		fired:    make(chan zk.Event, backend.DefaultChanLen),
+		reset:    make(chan struct{}, 1),
+		events:   events,
+	}
+	kvs, err := w.sync()
+	if err != nil {
+		p.watches.Remove(events)
+		return nil, err
+	}
+	w.kvs = kvs
+	//
+	p.Lock()
+	p.watchers[w] = struct{}{}
+	p.Unlock()
+	//
+	go w.watch()
+
+	return w.events, nil
+}
+
+// Unwatch stops the watch of the channel returned by Watch.
+func (p *Zookeeper) Unwatch(ch backend.EventChan) error {
+	p.watches.Remove(ch)
+	return nil
+}
+
+// KeepAlive sets value and updates the ttl for the specified key.
+// The ttl is bound to the session, the znode is recreated after session expiry.
+func (p *Zookeeper) KeepAlive(key, value string, ttl time.Duration) error {
+	p.Lock()
+	defer p.Unlock()
+	if err := p.setEphemeral(nodePath(key), value); err != nil {
+		return err
+	}
+	p.alive[key] = value
+	return nil
+}
+
+// UpdateKeepAlive updates the value of the key kept alive.
+func (p *Zookeeper) UpdateKeepAlive(key, value string) error {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.alive[key]; !ok {
+		return backend.ErrNotKeptAlive
+	}
+	if err := p.setEphemeral(nodePath(key), value); err != nil {
+		return err
+	}
+	p.alive[key] = value
+	return nil
+}
+
+// StopKeepAlive stops keeping the key alive and deletes it.
+func (p *Zookeeper) StopKeepAlive(key string) error {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.alive[key]; !ok {
+		return nil
+	}
+	delete(p.alive, key)
+	return p.deleteEphemeral(key)
+}
+
+// Close the provider connection.
+func (p *Zookeeper) Close() error {
+	p.cancel()
+	p.Lock()
+	for key := range p.alive {
+		if err := p.deleteEphemeral(key); err != nil {
+			log.Println("grc: zookeeper KeepAlive stopping, ", err.Error())
+		}
+	}
+	p.Unlock()
+	p.conn.Close()
+	return nil
+}
+
+func (p *Zookeeper) deleteEphemeral(key string) error {
+	err := p.conn.Delete(nodePath(key), -1)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	return err
+}
+
+func (p *Zookeeper) checkSession(events <-chan zk.Event) {
+	expired := false
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+
+		case evt, ok := <-events:
+			if !ok {
+				return
+			}
+			switch evt.State {
+			case zk.StateExpired:
+				log.Println("grc: zookeeper session expired")
+				expired = true
+			case zk.StateHasSession:
+				if !expired {
+					continue
+				}
+				expired = false
+				p.restoreSession()
+			}
+		}
+	}
+}
+
+// Recreate the ephemeral znodes and reset the watchers of the new session.
+func (p *Zookeeper) restoreSession() {
+	p.Lock()
+	defer p.Unlock()
+	for key, value := range p.alive {
+		if err := p.setEphemeral(nodePath(key), value); err != nil {
+			log.Println("grc: zookeeper restore ephemeral node failed, ", err.Error())
+		}
+	}
+	for w := range p.watchers {
+		select {
+		case w.reset <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *Zookeeper) setPersistent(zkPath, value string) error {
+	for {
+		_, err := p.conn.Set(zkPath, []byte(value), -1)
+		if err != zk.ErrNoNode {
+			return err
+		}
+		if err = p.createParents(zkPath); err != nil {
+			return err
+		}
+		_, err = p.conn.Create(zkPath, []byte(value), 0, p.acl)
+		if err != zk.ErrNodeExists {
+			return err
+		}
+	}
+}
+
+func (p *Zookeeper) setEphemeral(zkPath, value string) error {
+	for {
+		_, stat, err := p.conn.Get(zkPath)
+		if err == nil {
+			if stat.EphemeralOwner == p.conn.SessionID() {
+				_, err = p.conn.Set(zkPath, []byte(value), -1)
+				return err
+			}
+			// Replace the persistent or stale ephemeral znode.
+			err = p.conn.Delete(zkPath, stat.Version)
+			if err != nil && err != zk.ErrNoNode && err != zk.ErrBadVersion {
+				return err
+			}
+			continue
+		} else if err != zk.ErrNoNode {
+			return err
+		}
+		if err = p.createParents(zkPath); err != nil {
+			return err
+		}
+		_, err = p.conn.Create(zkPath, []byte(value), zk.FlagEphemeral, p.acl)
+		if err != zk.ErrNodeExists {
+			return err
+		}
+	}
+}
+
+func (p *Zookeeper) createParents(zkPath string) error {
+	parent := path.Dir(zkPath)
+	if parent == "/" {
+		return nil
+	}
+	exists, _, err := p.conn.Exists(parent)
+	if err != nil || exists {
+		return err
+	}
+	if err = p.createParents(parent); err != nil {
+		return err
+	}
+	_, err = p.conn.Create(parent, nil, 0, p.acl)
+	if err == zk.ErrNodeExists {
+		return nil
+	}
+	return err
+}
+
+func (p *Zookeeper) deleteTree(zkPath string) error {
+	children, _, err := p.conn.Children(zkPath)
+	if err == zk.ErrNoNode {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err = p.deleteTree(path.Join(zkPath, child)); err != nil {
+			return err
+		}
+	}
+	err = p.conn.Delete(zkPath, -1)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	return err
+}
+
+// walk invokes fn for every znode with a value under the directory key.
+func (p *Zookeeper) walk(key string, fn func(zkPath string, data []byte) error) error {
+	root := nodePath(key)
+	var visit func(zkPath string) error
+	visit = func(zkPath string) error {
+		data, _, err := p.conn.Get(zkPath)
+		if err == zk.ErrNoNode {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		children, _, err := p.conn.Children(zkPath)
+		if err == zk.ErrNoNode {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// Znodes without a value are directories.
+		if len(data) > 0 && !(zkPath == root && strings.HasSuffix(key, "/")) {
+			if err = fn(zkPath, data); err != nil {
+				return err
+			}
+		}
+		for _, child := range children {
+			if err = visit(path.Join(zkPath, child)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return visit(root)
+}
+
+// ZooKeeper paths must not end with a '/'.
+func nodePath(key string) string {
+	if !strings.HasPrefix(key, "/") {
+		key = "/" + key
+	}
+	if key != "/" {
+		key = strings.TrimSuffix(key, "/")
+	}
+	return key
+}
+
+// Restore the key format requested by the caller.
+func grcKey(requested, zkPath string) string {
+	if !strings.HasPrefix(requested, "/") {
+		return strings.TrimPrefix(zkPath, "/")
+	}
+	return zkPath
+}
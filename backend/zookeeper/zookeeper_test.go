@@ -0,0 +1,283 @@
+package zookeeper
+
+import (
+	"context"
+	"path"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc/backend"
+	"github.com/appootb/grc/backend/backendtest"
+	"github.com/go-zookeeper/zk"
+)
+
+type fakeNode struct {
+	data    []byte
+	version int32
+	owner   int64
+	mzxid   int64
+}
+
+// fakeConn is an in-memory ZooKeeper ensemble with one-shot watches.
+type fakeConn struct {
+	sync.Mutex
+	session int64
+	zxid    int64
+	nodes   map[string]*fakeNode
+	dataW   map[string][]chan zk.Event
+	childW  map[string][]chan zk.Event
+	events  chan zk.Event
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		session: 1,
+		nodes:   map[string]*fakeNode{"/": {}},
+		dataW:   make(map[string][]chan zk.Event),
+		childW:  make(map[string][]chan zk.Event),
+		events:  make(chan zk.Event, 10),
+	}
+}
+
+func (c *fakeConn) fire(watches map[string][]chan zk.Event, zkPath string, typ zk.EventType) {
+	for _, ch := range watches[zkPath] {
+		ch <- zk.Event{Type: typ, Path: zkPath}
+	}
+	delete(watches, zkPath)
+}
+
+func (c *fakeConn) watch(watches map[string][]chan zk.Event, zkPath string) <-chan zk.Event {
+	ch := make(chan zk.Event, 1)
+	watches[zkPath] = append(watches[zkPath], ch)
+	return ch
+}
+
+func (c *fakeConn) stat(n *fakeNode) *zk.Stat {
+	return &zk.Stat{
+		Version:        n.version,
+		EphemeralOwner: n.owner,
+		Mzxid:          n.mzxid,
+	}
+}
+
+func (c *fakeConn) children(zkPath string) []string {
+	var children []string
+	for p := range c.nodes {
+		if p != "/" && path.Dir(p) == zkPath {
+			children = append(children, path.Base(p))
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// expire drops the session with its ephemeral znodes and watches, then reconnects.
+func (c *fakeConn) expire() {
+	c.Lock()
+	for zkPath, n := range c.nodes {
+		if n.owner == c.session {
+			delete(c.nodes, zkPath)
+		}
+	}
+	for _, watches := range []map[string][]chan zk.Event{c.dataW, c.childW} {
+		for zkPath := range watches {
+			c.fire(watches, zkPath, zk.EventNotWatching)
+		}
+	}
+	c.session++
+	c.Unlock()
+	c.events <- zk.Event{Type: zk.EventSession, State: zk.StateExpired}
+	c.events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+}
+
+func (c *fakeConn) SessionID() int64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.session
+}
+
+func (c *fakeConn) Create(zkPath string, data []byte, flags int32, _ []zk.ACL) (string, error) {
+	c.Lock()
+	defer c.Unlock()
+	parent, ok := c.nodes[path.Dir(zkPath)]
+	if !ok {
+		return "", zk.ErrNoNode
+	}
+	if _, ok = c.nodes[zkPath]; ok {
+		return "", zk.ErrNodeExists
+	}
+	if parent.owner != 0 {
+		return "", zk.ErrNoChildrenForEphemerals
+	}
+	c.zxid++
+	n := &fakeNode{
+		data:  data,
+		mzxid: c.zxid,
+	}
+	if flags&zk.FlagEphemeral != 0 {
+		n.owner = c.session
+	}
+	c.nodes[zkPath] = n
+	c.fire(c.dataW, zkPath, zk.EventNodeCreated)
+	c.fire(c.childW, path.Dir(zkPath), zk.EventNodeChildrenChanged)
+	return zkPath, nil
+}
+
+func (c *fakeConn) Get(zkPath string) ([]byte, *zk.Stat, error) {
+	c.Lock()
+	defer c.Unlock()
+	n, ok := c.nodes[zkPath]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return n.data, c.stat(n), nil
+}
+
+func (c *fakeConn) Set(zkPath string, data []byte, version int32) (*zk.Stat, error) {
+	c.Lock()
+	defer c.Unlock()
+	n, ok := c.nodes[zkPath]
+	if !ok {
+		return nil, zk.ErrNoNode
+	}
+	if version != -1 && version != n.version {
+		return nil, zk.ErrBadVersion
+	}
+	c.zxid++
+	n.data, n.version, n.mzxid = data, n.version+1, c.zxid
+	c.fire(c.dataW, zkPath, zk.EventNodeDataChanged)
+	return c.stat(n), nil
+}
+
+func (c *fakeConn) Delete(zkPath string, version int32) error {
+	c.Lock()
+	defer c.Unlock()
+	n, ok := c.nodes[zkPath]
+	if !ok {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != n.version {
+		return zk.ErrBadVersion
+	}
+	if len(c.children(zkPath)) > 0 {
+		return zk.ErrNotEmpty
+	}
+	delete(c.nodes, zkPath)
+	c.fire(c.dataW, zkPath, zk.EventNodeDeleted)
+	c.fire(c.childW, zkPath, zk.EventNodeDeleted)
+	c.fire(c.childW, path.Dir(zkPath), zk.EventNodeChildrenChanged)
+	return nil
+}
+
+func (c *fakeConn) Exists(zkPath string) (bool, *zk.Stat, error) {
+	c.Lock()
+	defer c.Unlock()
+	n, ok := c.nodes[zkPath]
+	if !ok {
+		return false, nil, nil
+	}
+	return true, c.stat(n), nil
+}
+
+func (c *fakeConn) ExistsW(zkPath string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	c.Lock()
+	defer c.Unlock()
+	ch := c.watch(c.dataW, zkPath)
+	n, ok := c.nodes[zkPath]
+	if !ok {
+		return false, nil, ch, nil
+	}
+	return true, c.stat(n), ch, nil
+}
+
+func (c *fakeConn) Children(zkPath string) ([]string, *zk.Stat, error) {
+	c.Lock()
+	defer c.Unlock()
+	n, ok := c.nodes[zkPath]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return c.children(zkPath), c.stat(n), nil
+}
+
+func (c *fakeConn) ChildrenW(zkPath string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	c.Lock()
+	defer c.Unlock()
+	n, ok := c.nodes[zkPath]
+	if !ok {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+	return c.children(zkPath), c.stat(n), c.watch(c.childW, zkPath), nil
+}
+
+func (c *fakeConn) Close() {}
+
+func newTestProvider(t *testing.T) (*fakeConn, backend.Provider) {
+	conn := newFakeConn()
+	p := newProvider(context.Background(), conn, conn.events, zk.WorldACL(zk.PermAll))
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+	return conn, p
+}
+
+func TestZookeeper(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Provider {
+		_, p := newTestProvider(t)
+		return p
+	})
+}
+
+func TestZookeeper_SessionExpired(t *testing.T) {
+	conn, p := newTestProvider(t)
+
+	ch, err := p.Watch("/test/service/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.KeepAlive("/test/service/svc/node1", "n1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if evt := backendtest.WaitEvent(t, ch); evt.Type != backend.Put || evt.Value != "n1" {
+		t.Fatal("actual:", evt)
+	}
+	if err = p.Set("/test/ttl", "v", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	empty, err := p.Watch("/test/empty/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.expire()
+	// A Reset of each watch, not of each key.
+	for {
+		evt := backendtest.WaitEvent(t, ch)
+		if evt.Type == backend.Reset {
+			if evt.Key != "/test/service/" {
+				t.Fatal("actual:", evt)
+			}
+			break
+		}
+	}
+	if evt := backendtest.WaitEvent(t, empty); evt.Type != backend.Reset || evt.Key != "/test/empty/" {
+		t.Fatal("actual:", evt)
+	}
+	kvs, err := p.Get("/test/service/svc/node1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || kvs[0].Value != "n1" {
+		t.Fatal("actual:", kvs)
+	}
+	kvs, err = p.Get("/test/ttl", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 0 {
+		t.Fatal("actual:", kvs)
+	}
+}
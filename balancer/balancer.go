@@ -0,0 +1,90 @@
+package balancer
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/appootb/grc"
+)
+
+var (
+	// ErrNoNodes is returned if no node is available.
+	ErrNoNodes = errors.New("grc: no available nodes")
+)
+
+// Done is invoked when the request to the node picked is finished.
+type Done func()
+
+func noop() {}
+
+// Picker picks a node from the nodes updated, the implementations are safe for concurrent use.
+type Picker interface {
+	// Update replaces the nodes to pick from.
+	Update(nodes grc.Nodes)
+
+	// Pick returns a node, the key is used by the consistent hashing picker,
+	// done must be invoked when the request to the node is finished.
+	Pick(key string) (node *grc.Node, done Done, err error)
+}
+
+// Balancer keeps the picker up to date with the active nodes of the service.
+type Balancer struct {
+	Picker
+
+	mu    sync.Mutex
+	nodes grc.Nodes
+	stop  func()
+}
+
+// New returns the balancer of the service, the picker starts with the nodes loaded
+// and is updated by the discovery events until the balancer is closed.
+func New(rc *grc.RemoteConfig, service string, picker Picker) *Balancer {
+	b := &Balancer{
+		Picker: picker,
+		nodes:  grc.Nodes{},
+	}
+	b.update(rc.GetNodes(service), nil, nil)
+	b.stop = rc.WatchNodes(service, b.update)
+	return b
+}
+
+// Close stops updating the picker.
+func (b *Balancer) Close() {
+	b.stop()
+}
+
+func (b *Balancer) update(added, removed, updated grc.Nodes) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for addr := range removed {
+		delete(b.nodes, addr)
+	}
+	for addr, node := range added {
+		b.nodes[addr] = node
+	}
+	for addr, node := range updated {
+		b.nodes[addr] = node
+	}
+	b.Picker.Update(b.nodes.Active())
+}
+
+// sortedNodes returns the nodes ordered by address.
+func sortedNodes(nodes grc.Nodes) []*grc.Node {
+	list := make([]*grc.Node, 0, len(nodes))
+	for _, node := range nodes {
+		list = append(list, node)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Address < list[j].Address
+	})
+	return list
+}
+
+// weight returns the weight of the node, at least 1.
+func weight(node *grc.Node) int {
+	if node.Weight <= 0 {
+		return 1
+	}
+	return node.Weight
+}
@@ -0,0 +1,221 @@
+package balancer
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc"
+)
+
+func testNodes(weights ...int) grc.Nodes {
+	nodes := grc.Nodes{}
+	for i, w := range weights {
+		addr := "10.0.0." + strconv.Itoa(i+1)
+		nodes[addr] = &grc.Node{
+			Service: "svc",
+			Address: addr,
+			Weight:  w,
+		}
+	}
+	return nodes
+}
+
+func pick(t *testing.T, p Picker, key string) string {
+	node, done, err := p.Pick(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done()
+	return node.Address
+}
+
+func TestPicker_NoNodes(t *testing.T) {
+	for _, p := range []Picker{NewRoundRobin(), NewRandom(nil), NewP2C(nil), NewConsistentHash(0)} {
+		if _, _, err := p.Pick("key"); err != ErrNoNodes {
+			t.Fatal("actual:", err)
+		}
+		p.Update(testNodes(1))
+		p.Update(grc.Nodes{})
+		if _, _, err := p.Pick("key"); err != ErrNoNodes {
+			t.Fatal("actual:", err)
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	p := NewRoundRobin()
+	p.Update(testNodes(3, 1))
+
+	var seq []string
+	for i := 0; i < 8; i++ {
+		seq = append(seq, pick(t, p, ""))
+	}
+	expect := []string{"10.0.0.1", "10.0.0.1", "10.0.0.2", "10.0.0.1"}
+	for i, addr := range seq {
+		if addr != expect[i%4] {
+			t.Fatal("actual:", seq)
+		}
+	}
+}
+
+func TestRandom(t *testing.T) {
+	p := NewRandom(rand.NewSource(1))
+	p.Update(testNodes(3, 1, 0))
+
+	counts := map[string]int{}
+	for i := 0; i < 5000; i++ {
+		counts[pick(t, p, "")]++
+	}
+	// 3:1:1, the weight 0 counts as 1.
+	if counts["10.0.0.1"] < 2700 || counts["10.0.0.1"] > 3300 ||
+		counts["10.0.0.2"] < 800 || counts["10.0.0.3"] < 800 {
+		t.Fatal("actual:", counts)
+	}
+}
+
+func TestP2C(t *testing.T) {
+	p := NewP2C(rand.NewSource(1))
+	p.Update(testNodes(1, 1))
+
+	counts := map[string]int{}
+	var dones []Done
+	for i := 0; i < 100; i++ {
+		node, done, err := p.Pick("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[node.Address]++
+		dones = append(dones, done)
+	}
+	if counts["10.0.0.1"] != 50 || counts["10.0.0.2"] != 50 {
+		t.Fatal("actual:", counts)
+	}
+	// The in-flight requests are kept after updated.
+	p.Update(testNodes(1, 1, 1))
+	for _, n := range p.(*p2c).nodes {
+		if n.Address != "10.0.0.3" && n.inflight != 50 || n.Address == "10.0.0.3" && n.inflight != 0 {
+			t.Fatal("actual:", n.Address, n.inflight)
+		}
+	}
+	for _, done := range dones {
+		done()
+		done()
+	}
+	for _, n := range p.(*p2c).nodes {
+		if n.inflight != 0 {
+			t.Fatal("actual:", n.Address, n.inflight)
+		}
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	p := NewConsistentHash(0)
+	p.Update(testNodes(1, 1, 1))
+
+	owners := map[string]string{}
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		key := "user-" + strconv.Itoa(i)
+		owners[key] = pick(t, p, key)
+		counts[owners[key]]++
+		if addr := pick(t, p, key); addr != owners[key] {
+			t.Fatal("actual:", key, addr, owners[key])
+		}
+	}
+	if len(counts) != 3 {
+		t.Fatal("actual:", counts)
+	}
+	// Only the keys of the node removed move.
+	nodes := testNodes(1, 1, 1)
+	delete(nodes, "10.0.0.2")
+	p.Update(nodes)
+	for key, owner := range owners {
+		addr := pick(t, p, key)
+		if owner != "10.0.0.2" && addr != owner || addr == "10.0.0.2" {
+			t.Fatal("actual:", key, addr, owner)
+		}
+	}
+}
+
+func TestBalancer(t *testing.T) {
+	rc, err := grc.New(grc.WithDebugProvider(), grc.WithBasePath("/balancer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close(context.Background())
+
+	b := New(rc, "svc", NewRoundRobin())
+	defer b.Close()
+	h1, err := rc.RegisterNodeHandle("svc", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rc.RegisterNodeHandle("svc", "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	eventually := func(expect map[string]bool) {
+		deadline := time.Now().Add(time.Second * 3)
+		for {
+			picked := map[string]bool{}
+			for i := 0; i < 4; i++ {
+				if node, _, err := b.Pick(""); err == nil {
+					picked[node.Address] = true
+				}
+			}
+			if len(picked) == len(expect) {
+				ok := true
+				for addr := range expect {
+					ok = ok && picked[addr]
+				}
+				if ok {
+					return
+				}
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("actual:", picked)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+	eventually(map[string]bool{"10.0.0.1": true, "10.0.0.2": true})
+	// Draining nodes are not picked.
+	if err = h1.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(map[string]bool{"10.0.0.2": true})
+}
+
+func benchmarkPicker(b *testing.B, p Picker) {
+	p.Update(testNodes(1, 2, 3, 4, 5, 6, 7, 8, 9, 10))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			i++
+			node, done, err := p.Pick(strconv.Itoa(i))
+			if err != nil || node == nil {
+				b.Fatal(err)
+			}
+			done()
+		}
+	})
+}
+
+func BenchmarkRoundRobin(b *testing.B) {
+	benchmarkPicker(b, NewRoundRobin())
+}
+
+func BenchmarkRandom(b *testing.B) {
+	benchmarkPicker(b, NewRandom(nil))
+}
+
+func BenchmarkP2C(b *testing.B) {
+	benchmarkPicker(b, NewP2C(nil))
+}
+
+func BenchmarkConsistentHash(b *testing.B) {
+	benchmarkPicker(b, NewConsistentHash(0))
+}
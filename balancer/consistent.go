@@ -0,0 +1,78 @@
+package balancer
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/appootb/grc"
+)
+
+// DefaultReplicas is the virtual nodes of each weight unit on the hash ring.
+const DefaultReplicas = 100
+
+type ring struct {
+	hashes []uint32
+	nodes  map[uint32]*grc.Node
+}
+
+// consistentHash picks the node by the hash of the key.
+type consistentHash struct {
+	replicas int
+
+	mu   sync.RWMutex
+	ring *ring
+}
+
+// NewConsistentHash returns the consistent hashing picker, each node owns
+// replicas*Node.Weight virtual nodes on the ring, DefaultReplicas if replicas <= 0.
+func NewConsistentHash(replicas int) Picker {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return &consistentHash{
+		replicas: replicas,
+		ring:     &ring{},
+	}
+}
+
+func (p *consistentHash) Update(nodes grc.Nodes) {
+	r := &ring{
+		nodes: make(map[uint32]*grc.Node),
+	}
+	for _, node := range sortedNodes(nodes) {
+		for i := 0; i < p.replicas*weight(node); i++ {
+			h := crc32.ChecksumIEEE([]byte(node.Address + "#" + strconv.Itoa(i)))
+			// The first node in address order wins the collisions.
+			if _, ok := r.nodes[h]; ok {
+				continue
+			}
+			r.nodes[h] = node
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+	p.mu.Lock()
+	p.ring = r
+	p.mu.Unlock()
+}
+
+func (p *consistentHash) Pick(key string) (*grc.Node, Done, error) {
+	p.mu.RLock()
+	r := p.ring
+	p.mu.RUnlock()
+	if len(r.hashes) == 0 {
+		return nil, nil, ErrNoNodes
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= h
+	})
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.nodes[r.hashes[i]], noop, nil
+}
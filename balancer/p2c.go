@@ -0,0 +1,87 @@
+package balancer
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/appootb/grc"
+)
+
+type loadNode struct {
+	*grc.Node
+	inflight int64
+}
+
+// p2c is the power of two choices picker, the less loaded node is picked.
+type p2c struct {
+	mu    sync.Mutex
+	rand  *rand.Rand
+	nodes []*loadNode
+}
+
+// NewP2C returns the least-loaded picker choosing from two random nodes,
+// the load is the in-flight requests divided by Node.Weight.
+// A nil src is seeded with the current time.
+func NewP2C(src rand.Source) Picker {
+	return &p2c{
+		rand: newRand(src),
+	}
+}
+
+func (p *p2c) Update(nodes grc.Nodes) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Keep the in-flight requests of the existing nodes.
+	loads := make(map[string]*loadNode, len(p.nodes))
+	for _, n := range p.nodes {
+		loads[n.Address] = n
+	}
+	list := make([]*loadNode, 0, len(nodes))
+	for _, node := range sortedNodes(nodes) {
+		n, ok := loads[node.Address]
+		if ok {
+			n.Node = node
+		} else {
+			n = &loadNode{
+				Node: node,
+			}
+		}
+		list = append(list, n)
+	}
+	p.nodes = list
+}
+
+func (p *p2c) Pick(string) (*grc.Node, Done, error) {
+	p.mu.Lock()
+	var n *loadNode
+	switch len(p.nodes) {
+	case 0:
+		p.mu.Unlock()
+		return nil, nil, ErrNoNodes
+	case 1:
+		n = p.nodes[0]
+	default:
+		i := p.rand.Intn(len(p.nodes))
+		j := p.rand.Intn(len(p.nodes) - 1)
+		if j >= i {
+			j++
+		}
+		a, b := p.nodes[i], p.nodes[j]
+		n = a
+		// a.inflight/a.weight > b.inflight/b.weight
+		if atomic.LoadInt64(&a.inflight)*int64(weight(b.Node)) > atomic.LoadInt64(&b.inflight)*int64(weight(a.Node)) {
+			n = b
+		}
+	}
+	atomic.AddInt64(&n.inflight, 1)
+	node := n.Node
+	p.mu.Unlock()
+
+	var once sync.Once
+	return node, func() {
+		once.Do(func() {
+			atomic.AddInt64(&n.inflight, -1)
+		})
+	}, nil
+}
@@ -0,0 +1,56 @@
+package balancer
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/appootb/grc"
+)
+
+// random is the weighted random picker.
+type random struct {
+	mu    sync.Mutex
+	rand  *rand.Rand
+	nodes []*grc.Node
+	total int
+}
+
+// NewRandom returns the weighted random picker, a nil src is seeded with the current time.
+func NewRandom(src rand.Source) Picker {
+	return &random{
+		rand: newRand(src),
+	}
+}
+
+func newRand(src rand.Source) *rand.Rand {
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	return rand.New(src)
+}
+
+func (p *random) Update(nodes grc.Nodes) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nodes = sortedNodes(nodes)
+	p.total = 0
+	for _, node := range p.nodes {
+		p.total += weight(node)
+	}
+}
+
+func (p *random) Pick(string) (*grc.Node, Done, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.nodes) == 0 {
+		return nil, nil, ErrNoNodes
+	}
+	n := p.rand.Intn(p.total)
+	for _, node := range p.nodes {
+		if n -= weight(node); n < 0 {
+			return node, noop, nil
+		}
+	}
+	return p.nodes[len(p.nodes)-1], noop, nil
+}
@@ -0,0 +1,60 @@
+package balancer
+
+import (
+	"sync"
+
+	"github.com/appootb/grc"
+)
+
+type wrrNode struct {
+	*grc.Node
+	current int
+}
+
+// roundRobin is the smooth weighted round-robin picker.
+type roundRobin struct {
+	mu    sync.Mutex
+	nodes []*wrrNode
+	total int
+}
+
+// NewRoundRobin returns the smooth weighted round-robin picker, honouring Node.Weight.
+func NewRoundRobin() Picker {
+	return &roundRobin{}
+}
+
+func (p *roundRobin) Update(nodes grc.Nodes) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Keep the current weights of the existing nodes.
+	current := make(map[string]int, len(p.nodes))
+	for _, n := range p.nodes {
+		current[n.Address] = n.current
+	}
+	p.nodes = p.nodes[:0:0]
+	p.total = 0
+	for _, node := range sortedNodes(nodes) {
+		p.nodes = append(p.nodes, &wrrNode{
+			Node:    node,
+			current: current[node.Address],
+		})
+		p.total += weight(node)
+	}
+}
+
+func (p *roundRobin) Pick(string) (*grc.Node, Done, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var best *wrrNode
+	for _, n := range p.nodes {
+		n.current += weight(n.Node)
+		if best == nil || n.current > best.current {
+			best = n
+		}
+	}
+	if best == nil {
+		return nil, nil, ErrNoNodes
+	}
+	best.current -= p.total
+	return best.Node, noop, nil
+}
@@ -1,6 +1,7 @@
 package grc
 
 import (
+	"context"
 	"reflect"
 	"sync"
 )
@@ -17,6 +18,21 @@ type Callback interface {
 	EvtChan() chan<- DynamicType
 }
 
+// Flusher is implemented by the Callback managers which can wait for the pending callbacks.
+type Flusher interface {
+	// Flush returns when the callbacks of the events sent before are invoked.
+	Flush(ctx context.Context) error
+}
+
+// flushEvent is queued after the pending events.
+type flushEvent struct {
+	done chan struct{}
+}
+
+func (f *flushEvent) AtomicUpdate(string) {}
+
+func (f *flushEvent) Changed(UpdateEvent) {}
+
 var (
 	callbackMgr = newCallback()
 )
@@ -47,6 +63,23 @@ func (c *callback) EvtChan() chan<- DynamicType {
 	return c.evt
 }
 
+func (c *callback) Flush(ctx context.Context) error {
+	f := &flushEvent{
+		done: make(chan struct{}),
+	}
+	select {
+	case c.evt <- f:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-f.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (c *callback) operate() {
 	for {
 		select {
@@ -59,6 +92,10 @@ func (c *callback) operate() {
 			c.Unlock()
 
 		case val := <-c.evt:
+			if f, ok := val.(*flushEvent); ok {
+				close(f.done)
+				continue
+			}
 			c.RLock()
 			if events, ok := c.events[val]; ok {
 				for _, evt := range events {
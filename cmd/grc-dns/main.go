@@ -0,0 +1,74 @@
+// Command grc-dns answers the DNS queries of the services discovered by grc,
+// for the clients which can't link the grc package.
+//
+//	grc-dns -dsn etcd://h1:2379/base -addr :53 -domain grc.
+//
+// <service>.grc. resolves to the A/AAAA and SRV records of the active nodes of the service,
+// the SRV weights are the node weights, and the node metadata are the TXT records of
+// <unique_id>.<service>.grc.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/appootb/grc"
+	"github.com/miekg/dns"
+)
+
+func main() {
+	var (
+		dsn    = flag.String("dsn", os.Getenv("GRC_DSN"), "provider DSN, such as etcd://h1:2379/base, defaults to $GRC_DSN")
+		addr   = flag.String("addr", ":53", "listen address, UDP and TCP")
+		domain = flag.String("domain", "grc.", "domain of the services")
+		ttl    = flag.Duration("ttl", time.Second*5, "ttl of the records")
+	)
+	flag.Parse()
+	if *dsn == "" {
+		log.Fatalln("grc: -dsn is required")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rc, err := grc.New(grc.WithContext(ctx), grc.WithProviderURL(ctx, *dsn))
+	if err != nil {
+		log.Fatalln("grc: connect provider failed:", err.Error())
+	}
+	h := newHandler(rc, *domain, uint32(ttl.Seconds()))
+
+	servers := []*dns.Server{
+		{Addr: *addr, Net: "udp", Handler: h},
+		{Addr: *addr, Net: "tcp", Handler: h},
+	}
+	errCh := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *dns.Server) {
+			errCh <- srv.ListenAndServe()
+		}(srv)
+	}
+	log.Println("grc: dns serving", *domain, "on", *addr)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case s := <-sig:
+		log.Println("grc: dns shutting down on", s.String())
+	case err = <-errCh:
+		log.Println("grc: dns serve failed:", err.Error())
+	}
+	for _, srv := range servers {
+		_ = srv.Shutdown()
+	}
+	h.Close()
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer closeCancel()
+	if err = rc.Close(closeCtx); err != nil {
+		log.Println("grc: close failed:", err.Error())
+	}
+}
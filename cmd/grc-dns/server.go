@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/appootb/grc"
+	"github.com/miekg/dns"
+)
+
+// Records of the domain:
+//
+//	<service>.<domain>            A/AAAA of the nodes, SRV of the nodes
+//	_<service>._tcp.<domain>      SRV of the nodes, as queried by net.LookupSRV
+//	<unique_id>.<service>.<domain> A/AAAA and TXT metadata of a node
+//
+// Only the active nodes are answered.
+type handler struct {
+	rc     *grc.RemoteConfig
+	domain string
+	ttl    uint32
+
+	mu       sync.RWMutex
+	services map[string]*zone
+}
+
+// zone holds the active nodes of a service, updated from the discovery watch.
+type zone struct {
+	nodes grc.Nodes
+	stop  func()
+}
+
+func newHandler(rc *grc.RemoteConfig, domain string, ttl uint32) *handler {
+	return &handler{
+		rc:       rc,
+		domain:   strings.ToLower(dns.Fqdn(domain)),
+		ttl:      ttl,
+		services: map[string]*zone{},
+	}
+}
+
+// Close stops the discovery watches.
+func (h *handler) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for service, z := range h.services {
+		z.stop()
+		delete(h.services, service)
+	}
+}
+
+func (h *handler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	if len(req.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+	} else {
+		h.answer(m, req.Question[0])
+	}
+	size := dns.MinMsgSize
+	if w.RemoteAddr().Network() == "tcp" {
+		size = dns.MaxMsgSize
+	} else if opt := req.IsEdns0(); opt != nil {
+		size = int(opt.UDPSize())
+	}
+	m.Truncate(size)
+	if err := w.WriteMsg(m); err != nil {
+		log.Println("grc: dns write failed:", err.Error())
+	}
+}
+
+func (h *handler) answer(m *dns.Msg, q dns.Question) {
+	service, nodeID, srv, ok := h.parseName(q.Name)
+	if !ok {
+		m.Rcode = dns.RcodeRefused
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	z := h.lookup(service)
+	if z == nil || len(z.nodes) == 0 {
+		m.Rcode = dns.RcodeNameError
+		return
+	}
+	nodes := z.sorted()
+	if nodeID != "" {
+		node := nodeByID(nodes, nodeID)
+		if node == nil {
+			m.Rcode = dns.RcodeNameError
+			return
+		}
+		nodes = []*grc.Node{node}
+	}
+
+	switch {
+	case q.Qtype == dns.TypeSRV && nodeID == "":
+		for _, node := range nodes {
+			rr := h.srv(q.Name, service, node)
+			if rr == nil {
+				continue
+			}
+			m.Answer = append(m.Answer, rr)
+			target := rr.(*dns.SRV).Target
+			m.Extra = append(m.Extra, h.addr(target, node, dns.TypeANY)...)
+			if txt := h.txt(target, node); txt != nil {
+				m.Extra = append(m.Extra, txt)
+			}
+		}
+	case q.Qtype == dns.TypeTXT && nodeID != "":
+		if txt := h.txt(q.Name, nodes[0]); txt != nil {
+			m.Answer = append(m.Answer, txt)
+		}
+	case (q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA || q.Qtype == dns.TypeANY) && !srv:
+		for _, node := range nodes {
+			m.Answer = append(m.Answer, h.addr(q.Name, node, q.Qtype)...)
+		}
+	}
+}
+
+// parseName returns the service and the optional node unique id of the name.
+func (h *handler) parseName(name string) (service, nodeID string, srv bool, ok bool) {
+	// The service names are case sensitive, only the domain is matched case insensitively.
+	if !dns.IsSubDomain(h.domain, strings.ToLower(dns.Fqdn(name))) {
+		return "", "", false, false
+	}
+	labels := dns.SplitDomainName(name)
+	labels = labels[:len(labels)-dns.CountLabel(h.domain)]
+	switch {
+	case len(labels) == 0:
+		return "", "", false, false
+	case len(labels) == 1:
+		return labels[0], "", false, true
+	case len(labels) == 2 && strings.HasPrefix(labels[0], "_") && strings.HasPrefix(labels[1], "_"):
+		if proto := strings.ToLower(labels[1]); proto != "_tcp" && proto != "_udp" {
+			return "", "", false, false
+		}
+		return strings.TrimPrefix(labels[0], "_"), "", true, true
+	case len(labels) == 2:
+		return labels[1], labels[0], false, true
+	default:
+		return "", "", false, false
+	}
+}
+
+// lookup returns the zone of the service, the service is watched once it has nodes,
+// so that the names queried but not registered don't create watches.
+// Must be called with h.mu read locked.
+func (h *handler) lookup(service string) *zone {
+	if z, ok := h.services[service]; ok {
+		return z
+	}
+	nodes := h.rc.GetNodes(service).Active()
+	if len(nodes) == 0 {
+		return nil
+	}
+	h.mu.RUnlock()
+	defer h.mu.RLock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if z, ok := h.services[service]; ok {
+		return z
+	}
+	z := &zone{
+		nodes: nodes,
+	}
+	z.stop = h.rc.WatchNodes(service, func(added, removed, updated grc.Nodes) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		for key := range removed {
+			delete(z.nodes, key)
+		}
+		for _, changes := range []grc.Nodes{added, updated} {
+			for key, node := range changes {
+				if node.Draining || node.Status == grc.StatusCritical {
+					delete(z.nodes, key)
+				} else {
+					z.nodes[key] = node
+				}
+			}
+		}
+	})
+	h.services[service] = z
+	return z
+}
+
+func (z *zone) sorted() []*grc.Node {
+	nodes := make([]*grc.Node, 0, len(z.nodes))
+	for _, node := range z.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Address < nodes[j].Address
+	})
+	return nodes
+}
+
+func nodeByID(nodes []*grc.Node, nodeID string) *grc.Node {
+	for _, node := range nodes {
+		if strconv.FormatInt(node.UniqueID, 10) == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+func (h *handler) header(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{
+		Name:   name,
+		Rrtype: rrtype,
+		Class:  dns.ClassINET,
+		Ttl:    h.ttl,
+	}
+}
+
+// srv returns the SRV record of the node, nil if the address has no port.
+// The target is the node name if the host is an IP, or the host name otherwise.
+func (h *handler) srv(name, service string, node *grc.Node) dns.RR {
+	host, port, err := net.SplitHostPort(node.Address)
+	if err != nil {
+		return nil
+	}
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil
+	}
+	target := dns.Fqdn(host)
+	if net.ParseIP(host) != nil {
+		target = fmt.Sprintf("%d.%s.%s", node.UniqueID, service, h.domain)
+	}
+	weight := node.Weight
+	if weight < 1 {
+		weight = 1
+	} else if weight > 0xffff {
+		weight = 0xffff
+	}
+	return &dns.SRV{
+		Hdr:      h.header(name, dns.TypeSRV),
+		Priority: 0,
+		Weight:   uint16(weight),
+		Port:     uint16(portNum),
+		Target:   target,
+	}
+}
+
+// addr returns the A or AAAA record of the node, if the host is an IP of the qtype.
+func (h *handler) addr(name string, node *grc.Node, qtype uint16) []dns.RR {
+	host, _, err := net.SplitHostPort(node.Address)
+	if err != nil {
+		host = node.Address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		if qtype != dns.TypeA && qtype != dns.TypeANY {
+			return nil
+		}
+		return []dns.RR{&dns.A{Hdr: h.header(name, dns.TypeA), A: ip4}}
+	}
+	if qtype != dns.TypeAAAA && qtype != dns.TypeANY {
+		return nil
+	}
+	return []dns.RR{&dns.AAAA{Hdr: h.header(name, dns.TypeAAAA), AAAA: ip}}
+}
+
+// txt returns the metadata of the node as key=value strings, nil if no metadata.
+func (h *handler) txt(name string, node *grc.Node) dns.RR {
+	if len(node.Metadata) == 0 {
+		return nil
+	}
+	txt := make([]string, 0, len(node.Metadata))
+	for k, v := range node.Metadata {
+		txt = append(txt, k+"="+v)
+	}
+	sort.Strings(txt)
+	return &dns.TXT{
+		Hdr: h.header(name, dns.TypeTXT),
+		Txt: txt,
+	}
+}
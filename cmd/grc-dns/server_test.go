@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc"
+	"github.com/miekg/dns"
+)
+
+// serve starts the handler on a local UDP port, and returns the address.
+func serve(t *testing.T, rc *grc.RemoteConfig) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHandler(rc, "grc", 5)
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		Handler:           h,
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() {
+		_ = srv.Shutdown()
+		h.Close()
+	})
+	return pc.LocalAddr().String()
+}
+
+func exchange(t *testing.T, addr, name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	resp, err := dns.Exchange(m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// waitAnswers queries until the number of answers is n.
+func waitAnswers(t *testing.T, addr, name string, qtype uint16, n int) *dns.Msg {
+	for i := 0; i < 100; i++ {
+		resp := exchange(t, addr, name, qtype)
+		if len(resp.Answer) == n {
+			return resp
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	t.Fatal("answers timeout:", name, n)
+	return nil
+}
+
+func Test_DNS(t *testing.T) {
+	rc, err := grc.New(grc.WithDebugProvider(), grc.WithBasePath("/dns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close(context.Background())
+	addr := serve(t, rc)
+
+	if _, err = rc.RegisterNode("Test_DNS", "127.0.0.1:8001", grc.WithNodeWeight(2),
+		grc.WithNodeMetadata(map[string]string{"zone": "a", "version": "v2"})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rc.RegisterNode("Test_DNS", "[::1]:8002", grc.WithNodeWeight(3)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rc.RegisterNode("Test_DNS", "host.example:8003"); err != nil {
+		t.Fatal(err)
+	}
+
+	// SRV
+	resp := waitAnswers(t, addr, "Test_DNS.grc.", dns.TypeSRV, 3)
+	var srvs []string
+	for _, rr := range resp.Answer {
+		srv := rr.(*dns.SRV)
+		srvs = append(srvs, fmt.Sprintf("%s %d %d", srv.Target, srv.Weight, srv.Port))
+	}
+	sort.Strings(srvs)
+	expected := []string{
+		"1.Test_DNS.grc. 2 8001",
+		"2.Test_DNS.grc. 3 8002",
+		"host.example. 1 8003",
+	}
+	if !reflect.DeepEqual(srvs, expected) {
+		t.Fatal("actual:", srvs)
+	}
+	if len(resp.Extra) != 3 {
+		t.Fatal("actual:", resp.Extra)
+	}
+	resp = exchange(t, addr, "_Test_DNS._tcp.grc.", dns.TypeSRV)
+	if len(resp.Answer) != 3 {
+		t.Fatal("actual:", resp.Answer)
+	}
+
+	// A, AAAA and TXT
+	resp = exchange(t, addr, "Test_DNS.grc.", dns.TypeA)
+	if len(resp.Answer) != 1 || !resp.Answer[0].(*dns.A).A.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatal("actual:", resp.Answer)
+	}
+	resp = exchange(t, addr, "2.Test_DNS.grc.", dns.TypeAAAA)
+	if len(resp.Answer) != 1 || !resp.Answer[0].(*dns.AAAA).AAAA.Equal(net.ParseIP("::1")) {
+		t.Fatal("actual:", resp.Answer)
+	}
+	resp = exchange(t, addr, "1.Test_DNS.grc.", dns.TypeTXT)
+	if len(resp.Answer) != 1 || !reflect.DeepEqual(resp.Answer[0].(*dns.TXT).Txt, []string{"version=v2", "zone=a"}) {
+		t.Fatal("actual:", resp.Answer)
+	}
+
+	// Errors
+	if resp = exchange(t, addr, "Unknown.grc.", dns.TypeA); resp.Rcode != dns.RcodeNameError {
+		t.Fatal("actual:", resp.Rcode)
+	}
+	if resp = exchange(t, addr, "9.Test_DNS.grc.", dns.TypeA); resp.Rcode != dns.RcodeNameError {
+		t.Fatal("actual:", resp.Rcode)
+	}
+	if resp = exchange(t, addr, "Test_DNS.example.", dns.TypeA); resp.Rcode != dns.RcodeRefused {
+		t.Fatal("actual:", resp.Rcode)
+	}
+
+	// Live updates
+	h, err := rc.RegisterNodeHandle("Test_DNS", "127.0.0.2:8004")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitAnswers(t, addr, "Test_DNS.grc.", dns.TypeA, 2)
+	if err = h.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	waitAnswers(t, addr, "Test_DNS.grc.", dns.TypeA, 1)
+	if err = h.Deregister(); err != nil {
+		t.Fatal(err)
+	}
+	waitAnswers(t, addr, "Test_DNS.grc.", dns.TypeSRV, 3)
+}
@@ -0,0 +1,197 @@
+package grc
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appootb/grc/backend"
+)
+
+// LeaderLabel is the metadata key set on the registered node of the leader.
+const LeaderLabel = "leader"
+
+// ElectionTTL is the ttl of the candidates, the leadership is lost if not kept alive within it.
+var ElectionTTL = time.Second * 3
+
+// Leadership is returned by Campaign, the node campaigns until resigned.
+type Leadership struct {
+	rc       *RemoteConfig
+	service  string
+	nodeAddr string
+	election backend.Election
+
+	leader  int32
+	labelMu sync.Mutex
+	mu      sync.RWMutex
+	current string
+	changes chan string
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// Campaign starts campaigning for the leadership of the service with the node address,
+// the ctx bounds the creation of the candidate. The registered node of the address
+// is labeled with LeaderLabel while it is the leader, registered before or after Campaign.
+func (rc *RemoteConfig) Campaign(ctx context.Context, service, nodeAddr string) (*Leadership, error) {
+	elector, ok := rc.provider.(backend.Elector)
+	if !ok {
+		return nil, backend.ErrNotSupported
+	}
+	e, err := elector.NewElection(ctx, backend.ServiceElectionKey(rc.path, service), ElectionTTL)
+	if err != nil {
+		return nil, err
+	}
+	l := &Leadership{
+		rc:       rc,
+		service:  service,
+		nodeAddr: nodeAddr,
+		election: e,
+		changes:  make(chan string, 1),
+	}
+	var campaignCtx context.Context
+	campaignCtx, l.cancel = context.WithCancel(rc.ctx)
+	rc.mu.Lock()
+	rc.leaderships[l] = struct{}{}
+	rc.mu.Unlock()
+
+	l.wg.Add(2)
+	go l.observe(campaignCtx)
+	go l.campaign(campaignCtx)
+	return l, nil
+}
+
+// GetLeader returns the address of the current leader of the service.
+func (rc *RemoteConfig) GetLeader(ctx context.Context, service string) (string, error) {
+	elector, ok := rc.provider.(backend.Elector)
+	if !ok {
+		return "", backend.ErrNotSupported
+	}
+	return elector.Leader(ctx, backend.ServiceElectionKey(rc.path, service))
+}
+
+// IsLeader returns if the node is the leader.
+func (l *Leadership) IsLeader() bool {
+	return atomic.LoadInt32(&l.leader) == 1
+}
+
+// Leader returns the address of the current leader observed, empty if unknown.
+func (l *Leadership) Leader() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current
+}
+
+// Changes returns the addresses of the leaders on changes, only the latest is kept
+// if not consumed. The channel is closed when resigned.
+func (l *Leadership) Changes() <-chan string {
+	return l.changes
+}
+
+// Resign stops campaigning, and gives up the leadership.
+func (l *Leadership) Resign(ctx context.Context) error {
+	var err error
+	l.once.Do(func() {
+		l.rc.mu.Lock()
+		delete(l.rc.leaderships, l)
+		l.rc.mu.Unlock()
+
+		l.cancel()
+		l.wg.Wait()
+		if err = l.election.Resign(ctx); err != nil {
+			_ = l.election.Close()
+			return
+		}
+		err = l.election.Close()
+	})
+	return err
+}
+
+func (l *Leadership) campaign(ctx context.Context) {
+	defer l.wg.Done()
+
+	for {
+		lost, err := l.election.Campaign(ctx, l.nodeAddr)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Println("grc: campaign failed:", err.Error(), l.service, l.nodeAddr)
+			select {
+			case <-time.After(backend.RetryTimeout):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		l.setLeader(true)
+		select {
+		case <-lost:
+			l.setLeader(false)
+		case <-ctx.Done():
+			l.setLeader(false)
+			return
+		}
+	}
+}
+
+func (l *Leadership) observe(ctx context.Context) {
+	defer l.wg.Done()
+	defer close(l.changes)
+
+	for leader := range l.election.Observe(ctx) {
+		l.mu.Lock()
+		l.current = leader
+		l.mu.Unlock()
+		// Keep the latest only.
+		select {
+		case l.changes <- leader:
+		default:
+			select {
+			case <-l.changes:
+			default:
+			}
+			l.changes <- leader
+		}
+	}
+}
+
+// setLeader updates the state, and the label of the registered node.
+func (l *Leadership) setLeader(leader bool) {
+	v := int32(0)
+	if leader {
+		v = 1
+	}
+	atomic.StoreInt32(&l.leader, v)
+
+	l.rc.mu.Lock()
+	h := l.rc.nodes[backend.ServiceDiscoveryKey(l.rc.path, l.service, l.nodeAddr)]
+	l.rc.mu.Unlock()
+	if h != nil {
+		l.label(h)
+	}
+}
+
+// label applies the current state to the label of the node, serialized to keep the latest.
+func (l *Leadership) label(h *NodeHandle) {
+	l.labelMu.Lock()
+	defer l.labelMu.Unlock()
+	md := h.Node().Metadata
+	_, labeled := md[LeaderLabel]
+	leader := l.IsLeader()
+	if leader == labeled {
+		return
+	}
+	if leader {
+		md[LeaderLabel] = "true"
+	} else {
+		delete(md, LeaderLabel)
+	}
+	if err := h.SetMetadata(md); err != nil {
+		log.Println("grc: update leader label failed:", err.Error(), l.service, l.nodeAddr)
+	}
+}
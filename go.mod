@@ -3,6 +3,17 @@ module github.com/appootb/grc
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.5
+	github.com/go-redis/redis/v8 v8.11.0
+	github.com/go-zookeeper/zk v1.0.2
+	github.com/hashicorp/consul/api v1.9.1
+	github.com/mattn/go-sqlite3 v1.14.8
+	github.com/miekg/dns v1.1.26
 	go.etcd.io/etcd/api/v3 v3.5.0
 	go.etcd.io/etcd/client/v3 v3.5.0
+	go.etcd.io/etcd/server/v3 v3.5.0
+	google.golang.org/grpc v1.38.0
+	k8s.io/api v0.21.3
+	k8s.io/apimachinery v0.21.3
+	k8s.io/client-go v0.21.3
 )
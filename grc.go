@@ -3,6 +3,7 @@ package grc
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"reflect"
 	"strings"
@@ -29,38 +30,206 @@ func (e *InvalidUnmarshalError) Error() string {
 	return "grc: Config type(nil " + e.Type.String() + ")"
 }
 
+type configWatch struct {
+	watcher *backend.Watcher
+	done    chan struct{}
+}
+
+// closeError aggregates the errors of Close.
+type closeError []error
+
+func (e closeError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return "grc: close failed: " + strings.Join(msgs, "; ")
+}
+
 type RemoteConfig struct {
-	svc sync.Map
-	ctx context.Context
+	svc      sync.Map
+	monitors sync.Map
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu          sync.Mutex
+	configs     map[string][]*configWatch
+	nodes       map[string]*NodeHandle
+	leaderships map[*Leadership]struct{}
+
+	subMu sync.Mutex
+	subs  map[string]map[*nodesSubscriber]struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+	closed    bool
 
 	path         string
 	autoCreation bool
 	provider     backend.Provider
+	cp           backend.ContextProvider
+	err          error
 }
 
 func New(opts ...Option) (*RemoteConfig, error) {
 	rc := &RemoteConfig{
-		ctx: context.Background(),
+		ctx:         context.Background(),
+		configs:     make(map[string][]*configWatch),
+		nodes:       make(map[string]*NodeHandle),
+		subs:        make(map[string]map[*nodesSubscriber]struct{}),
+		leaderships: make(map[*Leadership]struct{}),
 	}
 	for _, opt := range opts {
 		opt.apply(rc)
 	}
+	if rc.err != nil {
+		return nil, rc.err
+	}
+	if rc.provider == nil {
+		return nil, errors.New("grc: provider required")
+	}
+	rc.cp = backend.NewContextProvider(rc.provider)
+	parent := rc.ctx
+	rc.ctx, rc.cancel = context.WithCancel(parent)
 
 	basePath := backend.ServiceDiscoveryPrefixKey(rc.path)
 	// Watch for service nodes updated.
-	evtChan, err := rc.provider.Watch(basePath, true)
+	watcher, err := backend.NewWatcher(rc.ctx, rc.cp, basePath, backend.WithPrefix())
 	if err != nil {
+		rc.cancel()
 		return nil, err
 	}
 	// Get services.
-	if err = rc.getServices(basePath); err != nil {
+	if err = rc.getServices(rc.ctx, basePath); err != nil {
+		_ = watcher.Close()
+		rc.cancel()
 		return nil, err
 	}
-	go rc.watchServiceEvent(basePath, evtChan)
+	rc.wg.Add(1)
+	go rc.watchServiceEvent(basePath, watcher)
+	// Close when the context of WithContext is done.
+	go func() {
+		select {
+		case <-parent.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), backend.WriteTimeout)
+			defer cancel()
+			if err := rc.Close(ctx); err != nil {
+				log.Println("grc: stopping..", err)
+			}
+		case <-rc.ctx.Done():
+		}
+	}()
 	return rc, nil
 }
 
+// Close deletes the nodes registered by this process, stops the watches,
+// waits for the pending callbacks, then closes the provider.
+func (rc *RemoteConfig) Close(ctx context.Context) error {
+	rc.closeOnce.Do(func() {
+		rc.closeErr = rc.close(ctx)
+	})
+	return rc.closeErr
+}
+
+func (rc *RemoteConfig) close(ctx context.Context) error {
+	var errs closeError
+
+	// Resign the leaderships.
+	rc.mu.Lock()
+	leaderships := make([]*Leadership, 0, len(rc.leaderships))
+	for l := range rc.leaderships {
+		leaderships = append(leaderships, l)
+	}
+	rc.mu.Unlock()
+	for _, l := range leaderships {
+		if err := l.Resign(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// Deregister the nodes.
+	rc.mu.Lock()
+	nodes := rc.nodes
+	rc.nodes = make(map[string]*NodeHandle)
+	rc.mu.Unlock()
+	for _, h := range nodes {
+		if err := h.deregister(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// Stop the watches.
+	rc.mu.Lock()
+	rc.closed = true
+	rc.mu.Unlock()
+	rc.cancel()
+	done := make(chan struct{})
+	go func() {
+		rc.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, ctx.Err())
+	}
+	// Wait for the pending callbacks.
+	if f, ok := callbackMgr.(Flusher); ok {
+		if err := f.Flush(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := rc.provider.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// addRoutine adds a goroutine waited by Close, false if closed.
+func (rc *RemoteConfig) addRoutine() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return false
+	}
+	rc.wg.Add(1)
+	return true
+}
+
+// Provider returns the backend provider.
+func (rc *RemoteConfig) Provider() backend.Provider {
+	return rc.provider
+}
+
+// BasePath returns the base path of the keys.
+func (rc *RemoteConfig) BasePath() string {
+	return rc.path
+}
+
 func (rc *RemoteConfig) RegisterNode(service, nodeAddr string, opts ...NodeOption) (int64, error) {
+	return rc.RegisterNodeContext(rc.ctx, service, nodeAddr, opts...)
+}
+
+// RegisterNodeContext registers the node with the requests bounded by the ctx,
+// the node is kept alive until the RemoteConfig is stopped.
+func (rc *RemoteConfig) RegisterNodeContext(ctx context.Context, service, nodeAddr string, opts ...NodeOption) (int64, error) {
+	h, err := rc.RegisterNodeHandleContext(ctx, service, nodeAddr, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return h.UniqueID(), nil
+}
+
+// RegisterNodeHandle registers the node, and returns the handle to update or deregister it.
+func (rc *RemoteConfig) RegisterNodeHandle(service, nodeAddr string, opts ...NodeOption) (*NodeHandle, error) {
+	return rc.RegisterNodeHandleContext(rc.ctx, service, nodeAddr, opts...)
+}
+
+// RegisterNodeHandleContext registers the node with the requests bounded by the ctx,
+// the node is kept alive until it is deregistered or the RemoteConfig is stopped.
+func (rc *RemoteConfig) RegisterNodeHandleContext(ctx context.Context, service, nodeAddr string, opts ...NodeOption) (*NodeHandle, error) {
 	node := &Node{
 		TTL:      time.Second * 3,
 		Service:  service,
@@ -72,27 +241,102 @@ func (rc *RemoteConfig) RegisterNode(service, nodeAddr string, opts ...NodeOptio
 		opt(node)
 	}
 
-	if node.ops {
-		err := rc.loadUniqueID(node)
+	switch {
+	case node.slots > 0:
+		// Claimed with the discovery key.
+	case node.ops:
+		err := rc.loadUniqueID(ctx, node)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-	} else {
-		uniqueID, err := rc.provider.Incr(backend.ServiceNodeIDIncrKey(rc.path, node.Service))
+	default:
+		uniqueID, err := rc.cp.Incr(ctx, backend.ServiceNodeIDIncrKey(rc.path, node.Service))
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 		node.UniqueID = uniqueID
 	}
 
+	var state *healthState
+	if node.check != nil {
+		state = &healthState{}
+		node.Status = state.next(runCheck(ctx, node.check, node, node.checkInterval))
+	}
+
 	key := backend.ServiceDiscoveryKey(rc.path, service, node.Address)
-	if err := rc.provider.KeepAlive(key, node.String(), node.TTL); err != nil {
-		return 0, err
+	var lost <-chan struct{}
+	if node.slots > 0 {
+		slot, err := rc.keepAliveSlot(ctx, key, node)
+		if err != nil {
+			return nil, err
+		}
+		lost = slot.Done()
+	} else if err := rc.cp.KeepAlive(ctx, key, node.String(), node.TTL); err != nil {
+		return nil, err
+	}
+	h := &NodeHandle{
+		rc:   rc,
+		key:  key,
+		node: *node,
+		lost: lost,
+	}
+	if node.check != nil {
+		var checkCtx context.Context
+		checkCtx, h.stopCheck = context.WithCancel(rc.ctx)
+		h.checkDone = make(chan struct{})
+		rc.wg.Add(1)
+		go h.checkNode(checkCtx, state)
+	}
+	rc.mu.Lock()
+	rc.nodes[key] = h
+	var leaderships []*Leadership
+	for l := range rc.leaderships {
+		if l.service == service && l.nodeAddr == node.Address {
+			leaderships = append(leaderships, l)
+		}
+	}
+	rc.mu.Unlock()
+	// Label the node if the leadership is won before registered.
+	for _, l := range leaderships {
+		l.label(h)
+	}
+	return h, nil
+}
+
+// keepAliveSlot claims the lowest free slot as the UniqueID, under the lease of the node.
+func (rc *RemoteConfig) keepAliveSlot(ctx context.Context, key string, node *Node) (*backend.Slot, error) {
+	allocator, ok := rc.provider.(backend.SlotAllocator)
+	if !ok {
+		return nil, backend.ErrNotSupported
+	}
+	return allocator.KeepAliveSlot(ctx, key, backend.ServiceNodeSlotPrefixKey(rc.path, node.Service),
+		node.slots, node.TTL, func(slot int64) string {
+			node.UniqueID = slot
+			return node.String()
+		})
+}
+
+// GetNodes returns the cached nodes of the service, filtered by the options,
+// the statuses of CheckNodes are applied. No nodes are returned if the options are invalid.
+func (rc *RemoteConfig) GetNodes(service string, opts ...QueryOption) Nodes {
+	nodes, _ := rc.getNodes(service, opts...)
+	return nodes
+}
+
+func (rc *RemoteConfig) getNodes(service string, opts ...QueryOption) (Nodes, error) {
+	return rc.applyHealth(service, rc.loadNodes(service)).filter(opts...)
+}
+
+// applyHealth returns the nodes with the statuses of CheckNodes applied.
+func (rc *RemoteConfig) applyHealth(service string, nodes Nodes) Nodes {
+	if m, ok := rc.monitors.Load(service); ok {
+		return m.(*healthMonitor).apply(nodes)
 	}
-	return node.UniqueID, nil
+	return nodes
 }
 
-func (rc *RemoteConfig) GetNodes(service string) Nodes {
+// loadNodes returns the cached nodes of the service.
+func (rc *RemoteConfig) loadNodes(service string) Nodes {
 	nodes, ok := rc.svc.Load(service)
 	if !ok {
 		return Nodes{}
@@ -100,7 +344,23 @@ func (rc *RemoteConfig) GetNodes(service string) Nodes {
 	return nodes.(Nodes)
 }
 
+// GetNodesContext refreshes the nodes of the service from the provider with the ctx,
+// an error is returned if the options are invalid.
+func (rc *RemoteConfig) GetNodesContext(ctx context.Context, service string, opts ...QueryOption) (Nodes, error) {
+	basePath := backend.ServiceDiscoveryPrefixKey(rc.path)
+	if err := rc.updateService(ctx, basePath, service); err != nil {
+		return nil, err
+	}
+	return rc.getNodes(service, opts...)
+}
+
 func (rc *RemoteConfig) RegisterConfig(service string, v interface{}) error {
+	return rc.RegisterConfigContext(rc.ctx, service, v)
+}
+
+// RegisterConfigContext registers the config with the requests bounded by the ctx,
+// the config is watched until the RemoteConfig is stopped.
+func (rc *RemoteConfig) RegisterConfigContext(ctx context.Context, service string, v interface{}) error {
 	cfg := reflect.ValueOf(v)
 	if cfg.Kind() != reflect.Ptr || cfg.IsNil() {
 		return &InvalidUnmarshalError{reflect.TypeOf(cfg)}
@@ -109,27 +369,53 @@ func (rc *RemoteConfig) RegisterConfig(service string, v interface{}) error {
 	basePath := backend.ServiceConfigKey(rc.path, service)
 	// Create/update default config value if not exist.
 	if rc.autoCreation {
-		err := rc.remoteConfigMigration(basePath, reflect.TypeOf(v))
+		err := rc.remoteConfigMigration(ctx, basePath, reflect.TypeOf(v))
 		if err != nil {
 			return err
 		}
 	}
 
 	// Watch for config updated.
-	evtChan, err := rc.provider.Watch(basePath, true)
+	watcher, err := backend.NewWatcher(rc.ctx, rc.cp, basePath, backend.WithPrefix())
 	if err != nil {
 		return err
 	}
 	// Initialize the config.
-	if err = rc.getConfig(basePath, configElem(cfg), false); err != nil {
+	if err = rc.getConfig(ctx, basePath, configElem(cfg), false); err != nil {
+		_ = watcher.Close()
 		return err
 	}
-	go rc.watchConfigEvent(basePath, evtChan, configElem(cfg))
+	w := &configWatch{
+		watcher: watcher,
+		done:    make(chan struct{}),
+	}
+	rc.mu.Lock()
+	rc.configs[service] = append(rc.configs[service], w)
+	rc.mu.Unlock()
+	rc.wg.Add(1)
+	go rc.watchConfigEvent(basePath, w, configElem(cfg))
+	return nil
+}
+
+// UnregisterConfig stops watching the configs registered for the service,
+// the configs keep the last values.
+func (rc *RemoteConfig) UnregisterConfig(service string) error {
+	rc.mu.Lock()
+	ws := rc.configs[service]
+	delete(rc.configs, service)
+	rc.mu.Unlock()
+
+	for _, w := range ws {
+		if err := w.watcher.Close(); err != nil {
+			return err
+		}
+		<-w.done
+	}
 	return nil
 }
 
-func (rc *RemoteConfig) remoteConfigMigration(basePath string, t reflect.Type) error {
-	kvs, err := rc.provider.Get(basePath, true)
+func (rc *RemoteConfig) remoteConfigMigration(ctx context.Context, basePath string, t reflect.Type) error {
+	kvs, err := rc.cp.Get(ctx, basePath, backend.WithPrefix())
 	if err != nil {
 		return err
 	}
@@ -137,16 +423,24 @@ func (rc *RemoteConfig) remoteConfigMigration(basePath string, t reflect.Type) e
 	for _, kv := range kvs {
 		delete(reflectKVs, kv.Key)
 	}
+	txn, ok := rc.provider.(backend.Txn)
 	for k, v := range reflectKVs {
-		if err = rc.provider.Set(k, v, 0); err != nil {
+		if !ok {
+			if err = rc.cp.Set(ctx, k, v); err != nil {
+				return err
+			}
+			continue
+		}
+		// Don't overwrite the keys created by the other nodes concurrently.
+		if _, err = txn.CreateIfNotExists(ctx, k, v); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (rc *RemoteConfig) getConfig(basePath string, cfg reflect.Value, forUpdate bool) error {
-	kvs, err := rc.provider.Get(basePath, true)
+func (rc *RemoteConfig) getConfig(ctx context.Context, basePath string, cfg reflect.Value, forUpdate bool) error {
+	kvs, err := rc.cp.Get(ctx, basePath, backend.WithPrefix())
 	if err != nil {
 		return err
 	}
@@ -158,25 +452,22 @@ func (rc *RemoteConfig) getConfig(basePath string, cfg reflect.Value, forUpdate
 	return nil
 }
 
-func (rc *RemoteConfig) watchConfigEvent(basePath string, ch backend.EventChan, cfg reflect.Value) {
+func (rc *RemoteConfig) watchConfigEvent(basePath string, w *configWatch, cfg reflect.Value) {
 	var (
 		err error
 	)
+	defer rc.wg.Done()
+	defer close(w.done)
 
-	for {
-		select {
-		case <-rc.ctx.Done():
-			return
-
-		case evt := <-ch:
-			if evt.Type == backend.Reset {
-				err = rc.getConfig(basePath, cfg, true)
-			} else {
-				err = rc.setConfig(basePath, &evt.KVPair, cfg, true)
-			}
-			if err != nil {
-				log.Println("grc: watchConfigEvent failed:", err.Error(), evt.Type, evt.Key)
-			}
+	// The channel is closed when the watcher is closed or the ctx is done.
+	for evt := range w.watcher.Events() {
+		if evt.Type == backend.Reset {
+			err = rc.getConfig(rc.ctx, basePath, cfg, true)
+		} else {
+			err = rc.setConfig(basePath, &evt.KVPair, cfg, true)
+		}
+		if err != nil {
+			log.Println("grc: watchConfigEvent failed:", err.Error(), evt.Type, evt.Key)
 		}
 	}
 }
@@ -207,10 +498,10 @@ func (rc *RemoteConfig) setConfig(basePath string, pair *backend.KVPair, cfg ref
 	return nil
 }
 
-func (rc *RemoteConfig) loadUniqueID(node *Node) error {
+func (rc *RemoteConfig) loadUniqueID(ctx context.Context, node *Node) error {
 	ops := Node{}
 	opsKey := backend.ServiceOpsKey(rc.path, node.Service, node.Address)
-	v, err := rc.provider.Get(opsKey, false)
+	v, err := rc.cp.Get(ctx, opsKey)
 	if err != nil {
 		return err
 	}
@@ -224,17 +515,17 @@ func (rc *RemoteConfig) loadUniqueID(node *Node) error {
 	}
 
 	ops.Weight = node.Weight
-	ops.UniqueID, err = rc.provider.Incr(backend.ServiceNodeIDIncrKey(rc.path, node.Service))
+	ops.UniqueID, err = rc.cp.Incr(ctx, backend.ServiceNodeIDIncrKey(rc.path, node.Service))
 	if err != nil {
 		return err
 	}
 	node.UniqueID = ops.UniqueID
-	return rc.provider.Set(opsKey, ops.String(), 0)
+	return rc.cp.Set(ctx, opsKey, ops.String())
 }
 
-func (rc *RemoteConfig) getServices(basePath string) error {
+func (rc *RemoteConfig) getServices(ctx context.Context, basePath string) error {
 	services := make(map[string]Nodes)
-	kvs, err := rc.provider.Get(basePath, true)
+	kvs, err := rc.cp.Get(ctx, basePath, backend.WithPrefix())
 	if err != nil {
 		return err
 	}
@@ -250,14 +541,21 @@ func (rc *RemoteConfig) getServices(basePath string) error {
 		}
 		svc[n.Address] = &n
 	}
+	// Services without nodes.
+	rc.svc.Range(func(name, _ interface{}) bool {
+		if _, ok := services[name.(string)]; !ok {
+			services[name.(string)] = Nodes{}
+		}
+		return true
+	})
 	for name, svc := range services {
-		rc.svc.Store(name, svc)
+		rc.storeService(name, svc)
 	}
 	return nil
 }
 
-func (rc *RemoteConfig) updateService(basePath, service string) error {
-	kvs, err := rc.provider.Get(basePath+service+"/", true)
+func (rc *RemoteConfig) updateService(ctx context.Context, basePath, service string) error {
+	kvs, err := rc.cp.Get(ctx, basePath+service+"/", backend.WithPrefix())
 	if err != nil {
 		return err
 	}
@@ -269,34 +567,26 @@ func (rc *RemoteConfig) updateService(basePath, service string) error {
 		}
 		svc[n.Address] = &n
 	}
-	rc.svc.Store(service, svc)
+	rc.storeService(service, svc)
 	return nil
 }
 
-func (rc *RemoteConfig) watchServiceEvent(basePath string, ch backend.EventChan) {
+func (rc *RemoteConfig) watchServiceEvent(basePath string, watcher *backend.Watcher) {
 	var (
 		err error
 	)
+	defer rc.wg.Done()
 
-	for {
-		select {
-		case <-rc.ctx.Done():
-			err = rc.provider.Close()
-			if err != nil {
-				log.Println("grc: stopping.. close provider failed", err)
-			}
-			return
-
-		case evt := <-ch:
-			if evt.Type == backend.Reset {
-				err = rc.getServices(basePath)
-			} else {
-				paths := strings.Split(strings.TrimPrefix(evt.Key, basePath), "/")
-				err = rc.updateService(basePath, paths[0])
-			}
-			if err != nil {
-				log.Println("grc: watchServiceEvent failed:", err.Error(), evt.Type, evt.Key)
-			}
+	// The channel is closed when the ctx is done.
+	for evt := range watcher.Events() {
+		if evt.Type == backend.Reset {
+			err = rc.getServices(rc.ctx, basePath)
+		} else {
+			paths := strings.Split(strings.TrimPrefix(evt.Key, basePath), "/")
+			err = rc.updateService(rc.ctx, basePath, paths[0])
+		}
+		if err != nil {
+			log.Println("grc: watchServiceEvent failed:", err.Error(), evt.Type, evt.Key)
 		}
 	}
 }
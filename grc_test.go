@@ -1,11 +1,17 @@
 package grc
 
 import (
+	"context"
+	"errors"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/appootb/grc/backend"
+	"github.com/appootb/grc/backend/etcd"
+	"github.com/appootb/grc/backend/memory"
 )
 
 var (
@@ -352,3 +358,833 @@ func Test_EmptyMap(t *testing.T) {
 		t.Fatal(cfg.MV)
 	}
 }
+
+func Test_WithProviderURL(t *testing.T) {
+	rc, err := New(WithProviderURL(context.Background(), "memory:///url"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.path != "/url" || rc.provider.Type() != backend.Memory {
+		t.Fatal("actual:", rc.path, rc.provider.Type())
+	}
+	_, err = New(WithProviderURL(context.Background(), "unknown://127.0.0.1/url"))
+	if err == nil {
+		t.Fatal("unknown scheme accepted")
+	}
+}
+
+func Test_WithEtcdProviderError(t *testing.T) {
+	_, err := New(WithEtcdProvider(context.Background(), []string{"127.0.0.1:2379"}, "", "",
+		etcd.WithTLSFiles("", "", "/nonexistent/ca.pem")))
+	if err == nil {
+		t.Fatal("error not returned")
+	}
+}
+
+func Test_RegisterConfigContext(t *testing.T) {
+	type Config struct {
+		IV int `default:"1"`
+	}
+	var cfg Config
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := grc.RegisterConfigContext(ctx, "Test_RegisterConfigContext", &cfg); err != context.Canceled {
+		t.Fatal("actual:", err)
+	}
+	if err := grc.RegisterConfigContext(context.Background(), "Test_RegisterConfigContext", &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IV != 1 {
+		t.Fatal("actual:", cfg.IV)
+	}
+}
+
+// migrationProvider holds the first Gets of the config migrations until all are done,
+// then updates the config as another node would before the creations.
+type migrationProvider struct {
+	backend.Provider
+	txn    backend.Txn
+	key    string
+	gets   int32
+	wg     sync.WaitGroup
+	once   sync.Once
+	update func()
+}
+
+func (p *migrationProvider) Get(key string, dir bool) (backend.KVPairs, error) {
+	kvs, err := p.Provider.Get(key, dir)
+	if key == p.key && atomic.AddInt32(&p.gets, 1) <= 2 {
+		p.wg.Done()
+		p.wg.Wait()
+		p.once.Do(p.update)
+	}
+	return kvs, err
+}
+
+func (p *migrationProvider) CompareAndSet(ctx context.Context, key, value string, revision int64) (bool, error) {
+	return p.txn.CompareAndSet(ctx, key, value, revision)
+}
+
+func (p *migrationProvider) CreateIfNotExists(ctx context.Context, key, value string) (bool, error) {
+	return p.txn.CreateIfNotExists(ctx, key, value)
+}
+
+func (p *migrationProvider) Txn(ctx context.Context, cmps []backend.Compare, ops []backend.TxnOp) (bool, error) {
+	return p.txn.Txn(ctx, cmps, ops)
+}
+
+func Test_ConcurrentConfigMigration(t *testing.T) {
+	type Config struct {
+		IV int `default:"1"`
+	}
+	mp := memory.NewProvider()
+	basePath := backend.ServiceConfigKey("/migration", "Test_ConcurrentConfigMigration")
+	var updated string
+	p := &migrationProvider{
+		Provider: mp,
+		txn:      mp.(backend.Txn),
+		key:      basePath,
+		update: func() {
+			for k, item := range parseConfig(reflect.TypeOf(&Config{}), "") {
+				item.Value = "3"
+				updated = item.String()
+				if err := mp.Set(basePath+k, updated, 0); err != nil {
+					t.Error(err)
+				}
+			}
+		},
+	}
+	p.wg.Add(2)
+
+	var (
+		wg   sync.WaitGroup
+		cfgs [2]Config
+	)
+	for i := range cfgs {
+		rc, err := New(WithProvider(p), WithConfigAutoCreation(), WithBasePath("/migration"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close(context.Background())
+		wg.Add(1)
+		go func(cfg *Config) {
+			defer wg.Done()
+			if err := rc.RegisterConfig("Test_ConcurrentConfigMigration", cfg); err != nil {
+				t.Error(err)
+			}
+		}(&cfgs[i])
+	}
+	wg.Wait()
+
+	// The update is not overwritten by the defaults.
+	kvs, err := mp.Get(basePath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || kvs[0].Value != updated {
+		t.Fatal("actual:", kvs)
+	}
+	for _, cfg := range cfgs {
+		if cfg.IV != 3 {
+			t.Fatal("actual:", cfg.IV)
+		}
+	}
+}
+
+func Test_GetNodesContext(t *testing.T) {
+	if _, err := grc.RegisterNodeContext(context.Background(), "Test_GetNodesContext", "127.0.0.1:8080"); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := grc.GetNodesContext(context.Background(), "Test_GetNodesContext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes["127.0.0.1:8080"] == nil {
+		t.Fatal("actual:", nodes)
+	}
+}
+
+func Test_NodeHandle(t *testing.T) {
+	h, err := grc.RegisterNodeHandle("Test_NodeHandle", "127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.SetWeight(10); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.SetMetadata(map[string]string{"zone": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := grc.GetNodesContext(context.Background(), "Test_NodeHandle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := nodes["127.0.0.1:8080"]
+	if node == nil || node.Weight != 10 || node.Metadata["zone"] != "a" || !node.Draining ||
+		node.UniqueID != h.UniqueID() || len(nodes.Active()) != 0 {
+		t.Fatal("actual:", nodes)
+	}
+	if err = h.Deregister(); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err = grc.GetNodesContext(context.Background(), "Test_NodeHandle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 0 {
+		t.Fatal("actual:", nodes)
+	}
+	// Not kept alive anymore.
+	if err = h.SetWeight(1); err != backend.ErrNotKeptAlive {
+		t.Fatal("actual:", err)
+	}
+}
+
+func Test_WatchNodes(t *testing.T) {
+	type change struct {
+		added, removed, updated Nodes
+	}
+	changes := make(chan change, 10)
+	stop := grc.WatchNodes("Test_WatchNodes", func(added, removed, updated Nodes) {
+		changes <- change{added, removed, updated}
+	})
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := grc.WatchNodesChan(ctx, "Test_WatchNodes")
+
+	expect := func(kind int) {
+		var c change
+		select {
+		case c = <-changes:
+		case <-time.After(time.Second * 3):
+			t.Fatal("callback timeout:", kind)
+		}
+		nodes := []Nodes{c.added, c.updated, c.removed}[kind]
+		if len(nodes) != 1 || nodes["127.0.0.1:8080"] == nil ||
+			len(c.added)+len(c.updated)+len(c.removed) != 1 {
+			t.Fatal("actual:", kind, c)
+		}
+		var evt *NodesEvent
+		select {
+		case evt = <-ch:
+		case <-time.After(time.Second * 3):
+			t.Fatal("channel timeout:", kind)
+		}
+		nodes = []Nodes{evt.Added, evt.Updated, evt.Removed}[kind]
+		if len(nodes) != 1 || nodes["127.0.0.1:8080"] == nil {
+			t.Fatal("actual:", kind, evt)
+		}
+	}
+
+	h, err := grc.RegisterNodeHandle("Test_WatchNodes", "127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(0)
+	if err = h.SetWeight(10); err != nil {
+		t.Fatal(err)
+	}
+	expect(1)
+	if err = h.Deregister(); err != nil {
+		t.Fatal(err)
+	}
+	expect(2)
+	cancel()
+	for evt := range ch {
+		t.Fatal("actual:", evt)
+	}
+}
+
+func Test_ParseSelector(t *testing.T) {
+	md := map[string]string{"zone": "us-east", "version": "v2"}
+	for s, expect := range map[string]bool{
+		"":                                  true,
+		"zone=us-east":                      true,
+		"zone==us-east":                     true,
+		"zone!=us-east":                     false,
+		"zone=us-east,version in (v2,v3)":   true,
+		"zone=us-east, version in (v3, v4)": false,
+		"version notin (v1)":                true,
+		"canary":                            false,
+		"!canary":                           true,
+		"!canary,zone":                      true,
+		"canary!=true":                      true,
+		"canary in (true)":                  false,
+	} {
+		sel, err := ParseSelector(s)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		if sel.Matches(md) != expect {
+			t.Fatal("actual:", s, !expect)
+		}
+	}
+	for _, s := range []string{"zone in ()", "=v", "zone name", "!"} {
+		if _, err := ParseSelector(s); err == nil {
+			t.Fatal("expected error:", s)
+		}
+	}
+}
+
+func Test_GetNodesSelector(t *testing.T) {
+	for addr, md := range map[string]map[string]string{
+		"node1": {"zone": "us-east", "version": "v1"},
+		"node2": {"zone": "us-east", "version": "v2"},
+		"node3": {"zone": "us-west", "version": "v3"},
+	} {
+		if _, err := grc.RegisterNode("Test_GetNodesSelector", addr, WithNodeMetadata(md)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := grc.GetNodesContext(context.Background(), "Test_GetNodesSelector"); err != nil {
+		t.Fatal(err)
+	}
+	check := func(nodes Nodes, expect ...string) {
+		if len(nodes) != len(expect) {
+			t.Fatal("actual:", nodes, expect)
+		}
+		for _, addr := range expect {
+			if nodes[addr] == nil {
+				t.Fatal("actual:", nodes, expect)
+			}
+		}
+	}
+	check(grc.GetNodes("Test_GetNodesSelector", WithSelector("zone=us-east,version in (v2,v3)")), "node2")
+	check(grc.GetNodes("Test_GetNodesSelector", WithSelector("version in (v2,v3)")), "node2", "node3")
+	check(grc.GetNodes("Test_GetNodesSelector", WithSelector("zone in (")))
+	if _, err := grc.GetNodesContext(context.Background(), "Test_GetNodesSelector", WithSelector("zone in (")); err == nil {
+		t.Fatal("invalid selector accepted")
+	}
+	check(grc.GetNodes("Test_GetNodesSelector", WithLocality("us-west")), "node3")
+	// Falls back to the other zones.
+	check(grc.GetNodes("Test_GetNodesSelector", WithSelector("version!=v3"), WithLocality("us-west")), "node1", "node2")
+	check(grc.GetNodes("Test_GetNodesSelector", WithLocality("eu")), "node1", "node2", "node3")
+}
+
+func Test_HealthCheck(t *testing.T) {
+	var healthy int32 = 1
+	check := func(ctx context.Context, node *Node) error {
+		if atomic.LoadInt32(&healthy) == 0 {
+			return errors.New("unhealthy")
+		}
+		return nil
+	}
+	h, err := grc.RegisterNodeHandle("Test_HealthCheck", "node1",
+		WithHealthCheck(check, time.Millisecond*20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Deregister()
+	if h.Node().Status != StatusPassing {
+		t.Fatal("actual:", h.Node())
+	}
+	waitStatus := func(status string) {
+		deadline := time.Now().Add(time.Second * 3)
+		for {
+			nodes, err := grc.GetNodesContext(context.Background(), "Test_HealthCheck", WithStatus(status))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(nodes) == 1 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("status timeout:", status, grc.GetNodes("Test_HealthCheck"))
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+	atomic.StoreInt32(&healthy, 0)
+	waitStatus(StatusCritical)
+	atomic.StoreInt32(&healthy, 1)
+	waitStatus(StatusPassing)
+}
+
+func Test_CheckNodes(t *testing.T) {
+	for _, addr := range []string{"node1", "node2"} {
+		if _, err := grc.RegisterNode("Test_CheckNodes", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := grc.GetNodesContext(context.Background(), "Test_CheckNodes"); err != nil {
+		t.Fatal(err)
+	}
+	stop := grc.CheckNodes("Test_CheckNodes", func(ctx context.Context, node *Node) error {
+		if node.Address == "node2" {
+			return errors.New("unhealthy")
+		}
+		return nil
+	}, time.Millisecond*10)
+
+	deadline := time.Now().Add(time.Second * 3)
+	for {
+		nodes := grc.GetNodes("Test_CheckNodes", WithStatus(StatusPassing))
+		critical := grc.GetNodes("Test_CheckNodes", WithStatus(StatusCritical))
+		if len(nodes) == 1 && nodes["node1"] != nil && len(critical) == 1 && critical["node2"] != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("actual:", nodes, critical)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	stop()
+	if nodes := grc.GetNodes("Test_CheckNodes", WithStatus(StatusPassing)); len(nodes) != 2 {
+		t.Fatal("actual:", nodes)
+	}
+}
+
+func Test_CheckNodesPublish(t *testing.T) {
+	for _, addr := range []string{"node1", "node2"} {
+		if _, err := grc.RegisterNode("Test_CheckNodesPublish", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := grc.GetNodesContext(context.Background(), "Test_CheckNodesPublish"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := grc.WatchNodesChan(ctx, "Test_CheckNodesPublish")
+	// The status published of node2.
+	wait := func(status string) {
+		timeout := time.After(time.Second * 3)
+		for {
+			select {
+			case evt := <-ch:
+				if node := evt.Updated["node2"]; node != nil && node.Status == status {
+					return
+				}
+			case <-timeout:
+				t.Fatal("status not published:", status)
+			}
+		}
+	}
+	check := func(ctx context.Context, node *Node) error {
+		if node.Address == "node2" {
+			return errors.New("unhealthy")
+		}
+		return nil
+	}
+	stop := grc.CheckNodes("Test_CheckNodesPublish", check, time.Millisecond*10)
+	wait(StatusCritical)
+	// Stopped, the statuses are not applied any more.
+	stop()
+	wait("")
+	// Stopping the replaced CheckNodes keeps the current one.
+	stop1 := grc.CheckNodes("Test_CheckNodesPublish", check, time.Millisecond*10)
+	stop2 := grc.CheckNodes("Test_CheckNodesPublish", check, time.Millisecond*10)
+	defer stop2()
+	wait(StatusCritical)
+	stop1()
+	if nodes := grc.GetNodes("Test_CheckNodesPublish", WithStatus(StatusCritical)); len(nodes) != 1 {
+		t.Fatal("actual:", nodes)
+	}
+}
+
+// The changes are diffed against the stored nodes, the statuses of CheckNodes are only published.
+func Test_CheckNodesWatch(t *testing.T) {
+	for _, addr := range []string{"node1", "node2"} {
+		if _, err := grc.RegisterNode("Test_CheckNodesWatch", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := grc.GetNodesContext(context.Background(), "Test_CheckNodesWatch"); err != nil {
+		t.Fatal(err)
+	}
+	stop := grc.CheckNodes("Test_CheckNodesWatch", func(ctx context.Context, node *Node) error {
+		if node.Address == "node2" {
+			return errors.New("unhealthy")
+		}
+		return nil
+	}, time.Millisecond*10)
+	defer stop()
+	deadline := time.Now().Add(time.Second * 3)
+	for len(grc.GetNodes("Test_CheckNodesWatch", WithStatus(StatusCritical))) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("node2 not critical")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := grc.WatchNodesChan(ctx, "Test_CheckNodesWatch")
+	next := func() *NodesEvent {
+		select {
+		case evt := <-ch:
+			return evt
+		case <-time.After(time.Second * 3):
+			t.Fatal("channel timeout")
+			return nil
+		}
+	}
+	if evt := next(); len(evt.Added) != 2 || evt.Added["node2"].Status != StatusCritical {
+		t.Fatal("actual:", evt)
+	}
+	if _, err := grc.RegisterNode("Test_CheckNodesWatch", "node3"); err != nil {
+		t.Fatal(err)
+	}
+	if evt := next(); len(evt.Added) != 1 || evt.Added["node3"] == nil || len(evt.Updated) != 0 || len(evt.Removed) != 0 {
+		t.Fatal("actual:", evt)
+	}
+}
+
+func Test_RegisterNodeSlots(t *testing.T) {
+	h1, err := grc.RegisterNodeHandle("Test_RegisterNodeSlots", "node1", WithUniqueIDSlots(2))
+	if err != nil || h1.UniqueID() != 0 {
+		t.Fatal("actual:", err)
+	}
+	id, err := grc.RegisterNode("Test_RegisterNodeSlots", "node2", WithUniqueIDSlots(2))
+	if err != nil || id != 1 {
+		t.Fatal("actual:", id, err)
+	}
+	if _, err = grc.RegisterNode("Test_RegisterNodeSlots", "node3", WithUniqueIDSlots(2)); err != backend.ErrSlotsExhausted {
+		t.Fatal("actual:", err)
+	}
+	select {
+	case <-h1.Lost():
+		t.Fatal("slot lost")
+	default:
+	}
+	if err = h1.Deregister(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-h1.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("slot released not notified")
+	}
+	if id, err = grc.RegisterNode("Test_RegisterNodeSlots", "node3", WithUniqueIDSlots(2)); err != nil || id != 0 {
+		t.Fatal("actual:", id, err)
+	}
+	nodes, err := grc.GetNodesContext(context.Background(), "Test_RegisterNodeSlots")
+	if err != nil || len(nodes) != 2 || nodes["node3"] == nil || nodes["node3"].UniqueID != 0 {
+		t.Fatal("actual:", nodes, err)
+	}
+}
+
+func Test_Campaign(t *testing.T) {
+	ctx := context.Background()
+	for _, addr := range []string{"node1", "node2"} {
+		if _, err := grc.RegisterNode("Test_Campaign", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l1, err := grc.Campaign(ctx, "Test_Campaign", "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitLeader := func(l *Leadership, expect string) {
+		for {
+			select {
+			case leader, ok := <-l.Changes():
+				if !ok {
+					t.Fatal("changes closed")
+				}
+				if leader == expect {
+					return
+				}
+			case <-time.After(time.Second * 3):
+				t.Fatal("leader timeout:", expect)
+			}
+		}
+	}
+	waitLeader(l1, "node1")
+	l2, err := grc.Campaign(ctx, "Test_Campaign", "node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitLeader(l2, "node1")
+	if leader, err := grc.GetLeader(ctx, "Test_Campaign"); err != nil || leader != "node1" {
+		t.Fatal("actual:", leader, err)
+	}
+
+	// Exposed via discovery.
+	waitLabel := func(expect string) {
+		deadline := time.Now().Add(time.Second * 3)
+		for {
+			nodes, err := grc.GetNodesContext(ctx, "Test_Campaign", WithSelector(LeaderLabel))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(nodes) == 1 && nodes[expect] != nil {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("actual:", nodes)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+	waitLabel("node1")
+	if !l1.IsLeader() || l2.IsLeader() || l2.Leader() != "node1" {
+		t.Fatal("actual:", l1.IsLeader(), l2.IsLeader(), l2.Leader())
+	}
+
+	if err = l1.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitLeader(l2, "node2")
+	waitLabel("node2")
+	if l1.IsLeader() || !l2.IsLeader() {
+		t.Fatal("actual:", l1.IsLeader(), l2.IsLeader())
+	}
+	if err = l2.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = grc.GetLeader(ctx, "Test_Campaign"); err != backend.ErrNoLeader {
+		t.Fatal("actual:", err)
+	}
+}
+
+func Test_CampaignBeforeRegister(t *testing.T) {
+	ctx := context.Background()
+	l, err := grc.Campaign(ctx, "Test_CampaignBeforeRegister", "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Resign(ctx)
+	deadline := time.Now().Add(time.Second * 3)
+	for !l.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("leader timeout")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// Labeled on registration.
+	h, err := grc.RegisterNodeHandle("Test_CampaignBeforeRegister", "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Deregister()
+	if h.Node().Metadata[LeaderLabel] != "true" {
+		t.Fatal("actual:", h.Node())
+	}
+	for {
+		nodes, err := grc.GetNodesContext(ctx, "Test_CampaignBeforeRegister", WithSelector(LeaderLabel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) == 1 && nodes["node1"] != nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("actual:", nodes)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func Test_Mutex(t *testing.T) {
+	var (
+		wg      sync.WaitGroup
+		holders int32
+		mu      sync.Mutex
+		last    int64
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := grc.NewMutex("Test_Mutex")
+			token, err := m.Lock(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if atomic.AddInt32(&holders, 1) != 1 {
+				t.Error("more than 1 holder")
+			}
+			// Fencing tokens increase in order of the holders.
+			mu.Lock()
+			if token <= last || m.Token() != token {
+				t.Error("actual:", token, last)
+			}
+			last = token
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&holders, -1)
+			if err = m.Unlock(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	m := grc.NewMutex("Test_Mutex")
+	if err := m.Unlock(context.Background()); err != ErrNotLocked {
+		t.Fatal("actual:", err)
+	}
+}
+
+// The mutex waiting for the lock held by another one doesn't block Token and Done.
+func Test_MutexWaiting(t *testing.T) {
+	holder := grc.NewMutex("Test_MutexWaiting")
+	if _, err := holder.Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Unlock(context.Background())
+
+	m := grc.NewMutex("Test_MutexWaiting")
+	ctx, cancel := context.WithCancel(context.Background())
+	locked := make(chan error, 1)
+	go func() {
+		_, err := m.Lock(ctx)
+		locked <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	done := make(chan struct{})
+	go func() {
+		if m.Token() != 0 || m.Done() != nil {
+			t.Error("locked while waiting")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Token blocked by Lock")
+	}
+	if _, err := m.Lock(context.Background()); err != ErrLocked {
+		t.Fatal("actual:", err)
+	}
+	cancel()
+	if err := <-locked; err == nil {
+		t.Fatal("locked after canceled")
+	}
+	if m.Token() != 0 {
+		t.Fatal("actual:", m.Token())
+	}
+}
+
+func Test_Semaphore(t *testing.T) {
+	var (
+		wg      sync.WaitGroup
+		holders int32
+	)
+	sem := grc.NewSemaphore("Test_Semaphore", 3)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			permit, err := sem.Acquire(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if atomic.AddInt32(&holders, 1) > 3 {
+				t.Error("more than 3 holders")
+			}
+			time.Sleep(time.Millisecond * 5)
+			atomic.AddInt32(&holders, -1)
+			if err = permit.Release(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func Test_UnregisterConfig(t *testing.T) {
+	type Config struct {
+		DIV Int `default:"1"`
+	}
+	var cfg Config
+	if err := grc.RegisterConfig("Test_UnregisterConfig", &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := grc.UnregisterConfig("Test_UnregisterConfig"); err != nil {
+		t.Fatal(err)
+	}
+	// Not updated any more.
+	key := backend.ServiceConfigKey(grc.path, "Test_UnregisterConfig") + "DIV"
+	if err := grc.provider.Set(key, `{"value":"2"}`, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if cfg.DIV.Int() != 1 {
+		t.Fatal("actual:", cfg.DIV.Int())
+	}
+}
+
+func Test_Close(t *testing.T) {
+	rc, err := New(WithDebugProvider(), WithBasePath("/close"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rc.RegisterNode("Test_Close", "127.0.0.1:8080"); err != nil {
+		t.Fatal(err)
+	}
+	var cfg struct {
+		DIV Int `default:"1"`
+	}
+	if err = rc.RegisterConfig("Test_Close", &cfg); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = rc.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	kvs, err := rc.provider.Get(backend.ServiceDiscoveryKey(rc.path, "Test_Close", "127.0.0.1:8080"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 0 {
+		t.Fatal("node not deregistered:", kvs)
+	}
+	if err = rc.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Subscribing while closing doesn't race with the wait of Close.
+func Test_CloseSubscribe(t *testing.T) {
+	rc, err := New(WithDebugProvider(), WithBasePath("/close"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rc.WatchNodesChan(ctx, "Test_CloseSubscribe")
+			}
+		}()
+	}
+	if err = rc.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	// Closed at once after Close.
+	select {
+	case _, ok := <-rc.WatchNodesChan(ctx, "Test_CloseSubscribe"):
+		if ok {
+			t.Fatal("channel not closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed")
+	}
+}
+
+func Test_CloseWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rc, err := New(WithDebugProvider(), WithContext(ctx), WithBasePath("/close"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	select {
+	case <-rc.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not closed")
+	}
+	rc.wg.Wait()
+}
@@ -0,0 +1,119 @@
+package grc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/appootb/grc/backend"
+)
+
+// NodeHandle is returned by RegisterNodeHandle, to update or deregister the node,
+// the updates are written to the same discovery key under its existing lease.
+type NodeHandle struct {
+	rc  *RemoteConfig
+	key string
+
+	mu   sync.Mutex
+	node Node
+
+	stopCheck context.CancelFunc
+	checkDone chan struct{}
+	lost      <-chan struct{}
+}
+
+// Lost returns a channel which is closed once the slot of the node registered WithUniqueIDSlots
+// is not held any more, deregistered or taken by another node after the lease expired,
+// then the node is not kept alive and should be registered again. Nil without slots.
+func (h *NodeHandle) Lost() <-chan struct{} {
+	return h.lost
+}
+
+// UniqueID returns the unique ID of the node.
+func (h *NodeHandle) UniqueID() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.node.UniqueID
+}
+
+// Node returns a copy of the registered node.
+func (h *NodeHandle) Node() Node {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	node := h.node
+	node.Metadata = copyMetadata(h.node.Metadata)
+	return node
+}
+
+// SetWeight updates the weight of the node.
+func (h *NodeHandle) SetWeight(weight int) error {
+	return h.update(func(node *Node) {
+		node.Weight = weight
+	})
+}
+
+// SetMetadata replaces the metadata of the node.
+func (h *NodeHandle) SetMetadata(md map[string]string) error {
+	return h.update(func(node *Node) {
+		node.Metadata = copyMetadata(md)
+	})
+}
+
+// Drain marks the node as draining, the consumers stop routing new traffic to it,
+// the node is kept alive until it is deregistered.
+func (h *NodeHandle) Drain() error {
+	return h.update(func(node *Node) {
+		node.Draining = true
+	})
+}
+
+// Deregister deletes the node and stops keeping it alive.
+func (h *NodeHandle) Deregister() error {
+	return h.DeregisterContext(h.rc.ctx)
+}
+
+// DeregisterContext deletes the node with the request bounded by the ctx.
+func (h *NodeHandle) DeregisterContext(ctx context.Context) error {
+	h.rc.mu.Lock()
+	if h.rc.nodes[h.key] == h {
+		delete(h.rc.nodes, h.key)
+	}
+	h.rc.mu.Unlock()
+	return h.deregister(ctx)
+}
+
+func (h *NodeHandle) deregister(ctx context.Context) error {
+	// Stop the health check first.
+	if h.stopCheck != nil {
+		h.stopCheck()
+		<-h.checkDone
+	}
+	if updater, ok := h.rc.provider.(backend.KeepAliveUpdater); ok {
+		return updater.StopKeepAlive(h.key)
+	}
+	return h.rc.cp.Delete(ctx, h.key)
+}
+
+func (h *NodeHandle) update(fn func(node *Node)) error {
+	updater, ok := h.rc.provider.(backend.KeepAliveUpdater)
+	if !ok {
+		return backend.ErrNotSupported
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	node := h.node
+	node.Metadata = copyMetadata(h.node.Metadata)
+	fn(&node)
+	if err := updater.UpdateKeepAlive(h.key, node.String()); err != nil {
+		return err
+	}
+	h.node = node
+	return nil
+}
+
+func copyMetadata(md map[string]string) map[string]string {
+	cp := make(map[string]string, len(md))
+	for k, v := range md {
+		cp[k] = v
+	}
+	return cp
+}
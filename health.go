@@ -0,0 +1,233 @@
+package grc
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Node status of the health checks.
+const (
+	StatusPassing  = "passing"
+	StatusWarning  = "warning"
+	StatusCritical = "critical"
+)
+
+// HealthFailureThreshold is the consecutive failures before a node is critical,
+// the node is warning before.
+const HealthFailureThreshold = 3
+
+// HealthCheck returns nil if the node is healthy.
+type HealthCheck func(ctx context.Context, node *Node) error
+
+// WithHealthCheck checks the node every interval by the registering process,
+// the status is written to the node, the first check is run before registering.
+func WithHealthCheck(check HealthCheck, interval time.Duration) NodeOption {
+	return func(node *Node) {
+		node.check = check
+		node.checkInterval = interval
+		if node.checkInterval <= 0 {
+			node.checkInterval = time.Second * 10
+		}
+	}
+}
+
+// WithStatus returns the nodes of the statuses, the nodes not checked are passing.
+func WithStatus(statuses ...string) QueryOption {
+	return func(o *queryOptions) {
+		o.statuses = append(o.statuses, statuses...)
+	}
+}
+
+// status returns the status of the node, passing if not checked.
+func (n *Node) status() string {
+	if n.Status == "" {
+		return StatusPassing
+	}
+	return n.Status
+}
+
+// healthState tracks the consecutive failures of a node.
+type healthState struct {
+	failures int
+}
+
+func (s *healthState) next(err error) string {
+	if err == nil {
+		s.failures = 0
+		return StatusPassing
+	}
+	s.failures++
+	if s.failures >= HealthFailureThreshold {
+		return StatusCritical
+	}
+	return StatusWarning
+}
+
+func runCheck(ctx context.Context, check HealthCheck, node *Node, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return check(ctx, node)
+}
+
+// checkNode checks the registered node, and updates the status if changed.
+func (h *NodeHandle) checkNode(ctx context.Context, state *healthState) {
+	defer h.rc.wg.Done()
+	defer close(h.checkDone)
+
+	ticker := time.NewTicker(h.node.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			node := h.Node()
+			status := state.next(runCheck(ctx, node.check, &node, node.checkInterval))
+			if status == node.status() || ctx.Err() != nil {
+				continue
+			}
+			err := h.update(func(node *Node) {
+				node.Status = status
+			})
+			if err != nil {
+				log.Println("grc: update node status failed:", err.Error(), h.key, status)
+			}
+		}
+	}
+}
+
+// healthMonitor holds the statuses of the nodes checked by the consumer.
+type healthMonitor struct {
+	mu       sync.RWMutex
+	statuses map[string]string
+}
+
+// apply returns the copies of the nodes with the worse of the statuses.
+func (m *healthMonitor) apply(nodes Nodes) Nodes {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	applied := make(Nodes, len(nodes))
+	for addr, node := range nodes {
+		status, ok := m.statuses[addr]
+		if !ok || statusLevel(status) <= statusLevel(node.status()) {
+			applied[addr] = node
+			continue
+		}
+		n := *node
+		n.Status = status
+		applied[addr] = &n
+	}
+	return applied
+}
+
+func statusLevel(status string) int {
+	switch status {
+	case StatusWarning:
+		return 1
+	case StatusCritical:
+		return 2
+	}
+	return 0
+}
+
+// CheckNodes checks the nodes of the service by the consumer every interval,
+// the statuses are applied to the nodes returned by GetNodes and published to the
+// subscribers of WatchNodes until stop is called.
+func (rc *RemoteConfig) CheckNodes(service string, check HealthCheck, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = time.Second * 10
+	}
+	m := &healthMonitor{
+		statuses: make(map[string]string),
+	}
+	ctx, cancel := context.WithCancel(rc.ctx)
+	rc.updateHealth(service, func() {
+		rc.monitors.Store(service, m)
+	})
+
+	rc.wg.Add(1)
+	go func() {
+		defer rc.wg.Done()
+
+		states := make(map[string]*healthState)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			nodes := rc.loadNodes(service)
+			statuses := make(map[string]string, len(nodes))
+			var (
+				mu sync.Mutex
+				wg sync.WaitGroup
+			)
+			for addr, node := range nodes {
+				state, ok := states[addr]
+				if !ok {
+					state = &healthState{}
+					states[addr] = state
+				}
+				wg.Add(1)
+				go func(addr string, node Node, state *healthState) {
+					defer wg.Done()
+					status := state.next(runCheck(ctx, check, &node, interval))
+					mu.Lock()
+					statuses[addr] = status
+					mu.Unlock()
+				}(addr, *node, state)
+			}
+			wg.Wait()
+			for addr := range states {
+				if _, ok := nodes[addr]; !ok {
+					delete(states, addr)
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			rc.updateHealth(service, func() {
+				m.mu.Lock()
+				m.statuses = statuses
+				m.mu.Unlock()
+			})
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		rc.updateHealth(service, func() {
+			// Replaced by another CheckNodes of the service.
+			if current, ok := rc.monitors.Load(service); ok && current == m {
+				rc.monitors.Delete(service)
+			}
+		})
+	}
+}
+
+// updateHealth changes the statuses of the service by fn, and notifies the subscribers
+// of the nodes whose statuses applied are changed.
+func (rc *RemoteConfig) updateHealth(service string, fn func()) {
+	rc.subMu.Lock()
+	defer rc.subMu.Unlock()
+
+	nodes := rc.loadNodes(service)
+	prev := rc.applyHealth(service, nodes)
+	fn()
+	subs := rc.subs[service]
+	if len(subs) == 0 {
+		return
+	}
+	evt := diffNodes(service, prev, rc.applyHealth(service, nodes))
+	if evt == nil {
+		return
+	}
+	for s := range subs {
+		s.push(evt)
+	}
+}
@@ -0,0 +1,79 @@
+// Package health implements the grc.HealthCheck of the common protocols.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/appootb/grc"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// TCP checks the node address can be connected.
+func TCP() grc.HealthCheck {
+	return func(ctx context.Context, node *grc.Node) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", node.Address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HTTP checks the GET request of the path returns 2xx, http://address/path.
+// The client is http.DefaultClient if nil.
+func HTTP(client *http.Client, path string) grc.HealthCheck {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return func(ctx context.Context, node *grc.Node) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+node.Address+path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("grc: health check %s status %d", req.URL, resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// GRPC checks the service is serving by the gRPC health checking protocol,
+// the empty service is the overall health of the server. The connection is
+// insecure if no dial options.
+func GRPC(service string, opts ...grpc.DialOption) grc.HealthCheck {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	opts = append(opts, grpc.WithBlock())
+	return func(ctx context.Context, node *grc.Node) error {
+		conn, err := grpc.DialContext(ctx, node.Address, opts...)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{
+			Service: service,
+		})
+		if err != nil {
+			return err
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("grc: health check %s status %s", node.Address, resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package health
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func check(check grc.HealthCheck, addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	return check(ctx, &grc.Node{
+		Address: addr,
+	})
+}
+
+func TestTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	if err = check(TCP(), addr); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+	if err = check(TCP(), addr); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	if err := check(HTTP(nil, "healthz"), addr); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(HTTP(srv.Client(), "/ready"), addr); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	defer srv.Stop()
+	addr := l.Addr().String()
+
+	if err = check(GRPC(""), addr); err != nil {
+		t.Fatal(err)
+	}
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_NOT_SERVING)
+	if err = check(GRPC("svc"), addr); err == nil {
+		t.Fatal("expected error")
+	}
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+	if err = check(GRPC("svc"), addr); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,165 @@
+// Package idgen implements the 64-bit time-ordered ID generator,
+// the worker ID is claimed for the registered node under its lease.
+package idgen
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ID layout: 1 bit unused, 41 bits milliseconds since the epoch, 10 bits worker ID, 12 bits sequence.
+const (
+	TimestampBits = 41
+	WorkerBits    = 10
+	SequenceBits  = 12
+
+	MaxWorkerID = 1<<WorkerBits - 1
+	MaxSequence = 1<<SequenceBits - 1
+
+	workerShift    = SequenceBits
+	timestampShift = SequenceBits + WorkerBits
+	maxTimestamp   = 1<<TimestampBits - 1
+)
+
+var (
+	// DefaultEpoch is the start time of the timestamps.
+	DefaultEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// DefaultMaxClockBackward is the clock regression waited for before failing.
+	DefaultMaxClockBackward = time.Millisecond * 10
+)
+
+var (
+	ErrWorkerIDRange     = errors.New("grc: worker id out of range")
+	ErrWorkerIDExhausted = errors.New("grc: no free worker id")
+	ErrWorkerIDLost      = errors.New("grc: worker id lost")
+	ErrClockBackwards    = errors.New("grc: clock moved backwards")
+	ErrTimestampOverflow = errors.New("grc: timestamp overflow")
+)
+
+type options struct {
+	epoch            time.Time
+	maxClockBackward time.Duration
+	now              func() time.Time
+}
+
+type Option func(*options)
+
+// WithEpoch sets the start time of the timestamps, should never be changed once IDs are issued.
+func WithEpoch(epoch time.Time) Option {
+	return func(o *options) {
+		o.epoch = epoch
+	}
+}
+
+// WithMaxClockBackward sets the clock regression waited for, Next fails with
+// ErrClockBackwards if the clock moves backwards more than it.
+func WithMaxClockBackward(d time.Duration) Option {
+	return func(o *options) {
+		o.maxClockBackward = d
+	}
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		epoch:            DefaultEpoch,
+		maxClockBackward: DefaultMaxClockBackward,
+		now:              time.Now,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Generator generates the unique IDs of a worker, safe for concurrent use.
+type Generator struct {
+	*options
+	workerID int64
+	release  func() error
+	// Closed when the worker ID claimed by New is not held any more.
+	lost <-chan struct{}
+
+	mu       sync.Mutex
+	last     int64
+	sequence int64
+}
+
+// NewGenerator returns the generator of the worker ID in [0, MaxWorkerID].
+func NewGenerator(workerID int64, opts ...Option) (*Generator, error) {
+	if workerID < 0 || workerID > MaxWorkerID {
+		return nil, ErrWorkerIDRange
+	}
+	return &Generator{
+		options:  newOptions(opts...),
+		workerID: workerID,
+		last:     -1,
+	}, nil
+}
+
+// WorkerID returns the worker ID of the generator.
+func (g *Generator) WorkerID() int64 {
+	return g.workerID
+}
+
+// Next returns the next ID, the IDs of a generator are increasing.
+func (g *Generator) Next() (int64, error) {
+	select {
+	case <-g.lost:
+		return 0, ErrWorkerIDLost
+	default:
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ts := g.timestamp()
+	if ts < g.last {
+		// Wait for the clock catching up.
+		backward := time.Duration(g.last-ts) * time.Millisecond
+		if backward > g.maxClockBackward {
+			return 0, ErrClockBackwards
+		}
+		time.Sleep(backward)
+		if ts = g.timestamp(); ts < g.last {
+			return 0, ErrClockBackwards
+		}
+	}
+	if ts == g.last {
+		g.sequence = (g.sequence + 1) & MaxSequence
+		if g.sequence == 0 {
+			// Sequence exhausted, wait for the next millisecond.
+			for ts <= g.last {
+				time.Sleep(time.Millisecond / 10)
+				ts = g.timestamp()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	if ts > maxTimestamp {
+		return 0, ErrTimestampOverflow
+	}
+	g.last = ts
+	return ts<<timestampShift | g.workerID<<workerShift | g.sequence, nil
+}
+
+// Parse returns the time, worker ID and sequence of the ID.
+func (g *Generator) Parse(id int64) (time.Time, int64, int64) {
+	ts := id >> timestampShift
+	return g.epoch.Add(time.Duration(ts) * time.Millisecond),
+		id >> workerShift & MaxWorkerID,
+		id & MaxSequence
+}
+
+// Close releases the worker ID claimed by New, Next fails after closed.
+func (g *Generator) Close() error {
+	if g.release == nil {
+		return nil
+	}
+	return g.release()
+}
+
+func (g *Generator) timestamp() int64 {
+	return int64(g.now().Sub(g.epoch) / time.Millisecond)
+}
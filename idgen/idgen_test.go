@@ -0,0 +1,201 @@
+package idgen
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc"
+	"github.com/appootb/grc/backend"
+)
+
+func TestGenerator_Next(t *testing.T) {
+	if _, err := NewGenerator(MaxWorkerID + 1); err != ErrWorkerIDRange {
+		t.Fatal("actual:", err)
+	}
+	g, err := NewGenerator(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		ids = map[int64]bool{}
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := int64(0)
+			for j := 0; j < 5000; j++ {
+				id, err := g.Next()
+				if err != nil || id <= last {
+					t.Error("actual:", id, last, err)
+					return
+				}
+				last = id
+				mu.Lock()
+				ids[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(ids) != 20000 {
+		t.Fatal("actual:", len(ids))
+	}
+	id, _ := g.Next()
+	ts, worker, _ := g.Parse(id)
+	if worker != 7 || time.Since(ts) > time.Second || time.Since(ts) < 0 {
+		t.Fatal("actual:", ts, worker)
+	}
+}
+
+func TestGenerator_Clock(t *testing.T) {
+	g, err := NewGenerator(1, WithMaxClockBackward(time.Millisecond*5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	calls := 0
+	g.now = func() time.Time {
+		calls++
+		// The sequence is exhausted in the first millisecond.
+		if calls <= MaxSequence+2 {
+			return now
+		}
+		return now.Add(time.Millisecond)
+	}
+	var last int64
+	for i := 0; i <= MaxSequence+1; i++ {
+		id, err := g.Next()
+		if err != nil || id <= last {
+			t.Fatal("actual:", i, id, last, err)
+		}
+		last = id
+	}
+	if _, _, seq := g.Parse(last); seq != 0 {
+		t.Fatal("actual:", seq)
+	}
+	// Moved backwards too much.
+	g.now = func() time.Time {
+		return now.Add(-time.Second)
+	}
+	if _, err = g.Next(); err != ErrClockBackwards {
+		t.Fatal("actual:", err)
+	}
+}
+
+func newTestRemoteConfig(t *testing.T) *grc.RemoteConfig {
+	rc, err := grc.New(grc.WithDebugProvider(), grc.WithBasePath("/idgen"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = rc.Close(context.Background())
+	})
+	return rc
+}
+
+func TestNew(t *testing.T) {
+	rc := newTestRemoteConfig(t)
+	ctx := context.Background()
+
+	h1, err := rc.RegisterNodeHandle("svc", "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g1, err := New(ctx, rc, h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := rc.RegisterNodeHandle("svc", "node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g2, err := New(ctx, rc, h2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g1.WorkerID() != 0 || g2.WorkerID() != 1 {
+		t.Fatal("actual:", g1.WorkerID(), g2.WorkerID())
+	}
+	// Released, and recycled.
+	if err = g1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g1.Next(); err != ErrWorkerIDLost {
+		t.Fatal("actual:", err)
+	}
+	kvs, err := rc.Provider().Get(backend.ServiceWorkerIDKey(rc.BasePath(), "svc", 0), false)
+	if err != nil || len(kvs) != 0 {
+		t.Fatal("actual:", kvs, err)
+	}
+	h3, err := rc.RegisterNodeHandle("svc", "node3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g3, err := New(ctx, rc, h3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g3.WorkerID() != 0 {
+		t.Fatal("actual:", g3.WorkerID())
+	}
+	if _, err = g3.Next(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNew_Exhausted(t *testing.T) {
+	rc := newTestRemoteConfig(t)
+	for id := int64(0); id <= MaxWorkerID; id++ {
+		if err := rc.Provider().Set(backend.ServiceWorkerIDKey(rc.BasePath(), "svc", id), "node", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := rc.RegisterNodeHandle("svc", "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = New(context.Background(), rc, h); err != ErrWorkerIDExhausted {
+		t.Fatal("actual:", err)
+	}
+}
+
+// The generator stops once the worker ID is not held any more.
+func TestNew_Lost(t *testing.T) {
+	rc := newTestRemoteConfig(t)
+	h, err := rc.RegisterNodeHandle("svc", "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := New(context.Background(), rc, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Next(); err != nil {
+		t.Fatal(err)
+	}
+	// The provider is closed, the lease is not kept alive.
+	if err = rc.Provider().Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Next(); err != ErrWorkerIDLost {
+		t.Fatal("actual:", err)
+	}
+}
+
+func BenchmarkGenerator_Next(b *testing.B) {
+	g, err := NewGenerator(1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = g.Next(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package idgen
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/appootb/grc"
+	"github.com/appootb/grc/backend"
+)
+
+// New claims the lowest free worker ID for the registered node, and returns the generator of it.
+// The worker ID is kept alive under a lease with the ttl of the node, and recycled once the lease
+// expires, Next fails with ErrWorkerIDLost if the worker ID is not held any more.
+// The provider must implement backend.SlotAllocator and backend.KeepAliveUpdater.
+func New(ctx context.Context, rc *grc.RemoteConfig, h *grc.NodeHandle, opts ...Option) (*Generator, error) {
+	provider := rc.Provider()
+	allocator, ok := provider.(backend.SlotAllocator)
+	if !ok {
+		return nil, backend.ErrNotSupported
+	}
+	updater, ok := provider.(backend.KeepAliveUpdater)
+	if !ok {
+		return nil, backend.ErrNotSupported
+	}
+	g, err := NewGenerator(0, opts...)
+	if err != nil {
+		return nil, err
+	}
+	node := h.Node()
+	key := backend.ServiceWorkerKey(rc.BasePath(), node.Service, strconv.FormatInt(node.UniqueID, 10))
+	slot, err := allocator.KeepAliveSlot(ctx, key, backend.ServiceWorkerIDPrefixKey(rc.BasePath(), node.Service),
+		MaxWorkerID+1, node.TTL, func(slot int64) string {
+			return strconv.FormatInt(slot, 10)
+		})
+	if err == backend.ErrSlotsExhausted {
+		return nil, ErrWorkerIDExhausted
+	} else if err != nil {
+		return nil, err
+	}
+	g.workerID = slot.ID
+	g.lost = slot.Done()
+	g.release = func() error {
+		return updater.StopKeepAlive(key)
+	}
+	return g, nil
+}
@@ -0,0 +1,116 @@
+package grc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/appootb/grc/backend"
+)
+
+var (
+	// ErrNotLocked is returned by Unlock if the mutex is not locked.
+	ErrNotLocked = errors.New("grc: mutex not locked")
+	// ErrLocked is returned by Lock if the mutex is locked by the same instance.
+	ErrLocked = errors.New("grc: mutex already locked")
+)
+
+// LockTTL is the ttl of the locks, the lock is lost if not kept alive within it.
+var LockTTL = time.Second * 10
+
+// Semaphore limits the concurrent holders of the name to n, across the processes.
+type Semaphore struct {
+	rc   *RemoteConfig
+	name string
+	n    int
+}
+
+// NewSemaphore returns the semaphore of the name with n permits.
+func (rc *RemoteConfig) NewSemaphore(name string, n int) *Semaphore {
+	return &Semaphore{
+		rc:   rc,
+		name: name,
+		n:    n,
+	}
+}
+
+// Acquire blocks until a permit is acquired or the ctx is done,
+// the Token of the permit is the fencing token to pass to the resources guarded.
+func (s *Semaphore) Acquire(ctx context.Context) (backend.Permit, error) {
+	locker, ok := s.rc.provider.(backend.Locker)
+	if !ok {
+		return nil, backend.ErrNotSupported
+	}
+	return locker.Acquire(ctx, backend.ServiceLockKey(s.rc.path, s.name), s.n, LockTTL)
+}
+
+// Mutex is the distributed lock of the name, an instance should be locked once at a time.
+type Mutex struct {
+	sem *Semaphore
+
+	mu      sync.Mutex
+	locking bool
+	permit  backend.Permit
+}
+
+// NewMutex returns the mutex of the name.
+func (rc *RemoteConfig) NewMutex(name string) *Mutex {
+	return &Mutex{
+		sem: rc.NewSemaphore(name, 1),
+	}
+}
+
+// Lock blocks until locked or the ctx is done, and returns the fencing token.
+func (m *Mutex) Lock(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	if m.permit != nil || m.locking {
+		m.mu.Unlock()
+		return 0, ErrLocked
+	}
+	m.locking = true
+	m.mu.Unlock()
+
+	// Acquired without the lock, so Token and Done don't block.
+	permit, err := m.sem.Acquire(ctx)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.locking = false
+	if err != nil {
+		return 0, err
+	}
+	m.permit = permit
+	return permit.Token(), nil
+}
+
+// Unlock releases the lock.
+func (m *Mutex) Unlock(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.permit == nil {
+		return ErrNotLocked
+	}
+	err := m.permit.Release(ctx)
+	m.permit = nil
+	return err
+}
+
+// Token returns the fencing token of the lock held, 0 if not locked.
+func (m *Mutex) Token() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.permit == nil {
+		return 0
+	}
+	return m.permit.Token()
+}
+
+// Done is closed when the lock held is lost or unlocked, nil if not locked.
+func (m *Mutex) Done() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.permit == nil {
+		return nil
+	}
+	return m.permit.Done()
+}
@@ -22,6 +22,15 @@ func WithOpsConfig() NodeOption {
 	}
 }
 
+// WithUniqueIDSlots claims the lowest free slot in [0, n) as the UniqueID, under the lease
+// of the node, the slot is released when the node lease expires. The registration fails with
+// backend.ErrSlotsExhausted if no slot is free.
+func WithUniqueIDSlots(n int64) NodeOption {
+	return func(node *Node) {
+		node.slots = n
+	}
+}
+
 func WithNodeWeight(weight int) NodeOption {
 	return func(node *Node) {
 		node.Weight = weight
@@ -35,7 +44,10 @@ func WithNodeMetadata(md map[string]string) NodeOption {
 }
 
 type Node struct {
-	ops bool
+	ops           bool
+	slots         int64
+	check         HealthCheck
+	checkInterval time.Duration
 
 	TTL      time.Duration     `json:"ttl,omitempty"`
 	UniqueID int64             `json:"unique_id,omitempty"`
@@ -43,6 +55,8 @@ type Node struct {
 	Address  string            `json:"address,omitempty"`
 	Weight   int               `json:"weight,omitempty"`
 	Metadata map[string]string `json:"metadata,omitempty"`
+	Draining bool              `json:"draining,omitempty"`
+	Status   string            `json:"status,omitempty"`
 }
 
 func (n Node) String() string {
@@ -51,3 +65,14 @@ func (n Node) String() string {
 }
 
 type Nodes map[string]*Node
+
+// Active returns the nodes not draining nor critical.
+func (n Nodes) Active() Nodes {
+	active := make(Nodes, len(n))
+	for k, node := range n {
+		if !node.Draining && node.Status != StatusCritical {
+			active[k] = node
+		}
+	}
+	return active
+}
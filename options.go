@@ -2,10 +2,18 @@ package grc
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/appootb/grc/backend"
+	"github.com/appootb/grc/backend/consul"
 	"github.com/appootb/grc/backend/etcd"
+	"github.com/appootb/grc/backend/file"
+	"github.com/appootb/grc/backend/kubernetes"
 	"github.com/appootb/grc/backend/memory"
+	"github.com/appootb/grc/backend/redis"
+	"github.com/appootb/grc/backend/sql"
+	"github.com/appootb/grc/backend/zookeeper"
+	k8s "k8s.io/client-go/kubernetes"
 )
 
 // Option interface sets options such as provider, autoCreation, etc.
@@ -53,17 +61,111 @@ func WithProvider(provider backend.Provider) Option {
 	})
 }
 
+// WithProviderURL creates the provider from the DSN, the base path is taken from the URL.
+// The scheme is one of the registered providers, such as
+// etcd://user:pass@h1:2379,h2:2379/base?dial_timeout=5s, memory:///base or file:///path?base=/base.
+func WithProviderURL(ctx context.Context, dsn string) Option {
+	return newFuncServerOption(func(rc *RemoteConfig) {
+		provider, path, err := backend.Open(ctx, dsn)
+		if err != nil {
+			rc.err = err
+			return
+		}
+		rc.path = path
+		rc.provider = provider
+	})
+}
+
 func WithDebugProvider() Option {
 	return newFuncServerOption(func(rc *RemoteConfig) {
 		rc.provider = memory.NewProvider()
 	})
 }
 
-func WithEtcdProvider(ctx context.Context, endPoints []string, username, password string) Option {
+func WithEtcdProvider(ctx context.Context, endPoints []string, username, password string, opts ...etcd.Option) Option {
+	return newFuncServerOption(func(rc *RemoteConfig) {
+		provider, err := etcd.NewProvider(ctx, endPoints, username, password, opts...)
+		if err != nil {
+			rc.err = fmt.Errorf("grc: connect to etcd failed: %w", err)
+			return
+		}
+		rc.provider = provider
+	})
+}
+
+// WithConsulProvider uses the Consul KV, the node ttls are raised to consul.MinSessionTTL.
+func WithConsulProvider(ctx context.Context, address, token string) Option {
+	return newFuncServerOption(func(rc *RemoteConfig) {
+		provider, err := consul.NewProvider(ctx, address, token)
+		if err != nil {
+			rc.err = fmt.Errorf("grc: connect to consul failed: %w", err)
+			return
+		}
+		rc.provider = provider
+	})
+}
+
+func WithZookeeperProvider(ctx context.Context, servers []string, username, password string) Option {
+	return newFuncServerOption(func(rc *RemoteConfig) {
+		provider, err := zookeeper.NewProvider(ctx, servers, username, password)
+		if err != nil {
+			rc.err = fmt.Errorf("grc: connect to zookeeper failed: %w", err)
+			return
+		}
+		rc.provider = provider
+	})
+}
+
+func WithRedisProvider(ctx context.Context, addr, password string, db int) Option {
+	return newFuncServerOption(func(rc *RemoteConfig) {
+		provider, err := redis.NewProvider(ctx, addr, password, db)
+		if err != nil {
+			rc.err = fmt.Errorf("grc: connect to redis failed: %w", err)
+			return
+		}
+		rc.provider = provider
+	})
+}
+
+func WithFileProvider(ctx context.Context, root string) Option {
+	return newFuncServerOption(func(rc *RemoteConfig) {
+		provider, err := file.NewProvider(ctx, root)
+		if err != nil {
+			rc.err = fmt.Errorf("grc: open file provider failed: %w", err)
+			return
+		}
+		rc.provider = provider
+	})
+}
+
+func WithSQLProvider(ctx context.Context, driver, dsn string) Option {
+	return newFuncServerOption(func(rc *RemoteConfig) {
+		provider, err := sql.NewProvider(ctx, driver, dsn)
+		if err != nil {
+			rc.err = fmt.Errorf("grc: connect to database failed: %w", err)
+			return
+		}
+		rc.provider = provider
+	})
+}
+
+func WithKubernetesProvider(ctx context.Context, namespace string) Option {
+	return newFuncServerOption(func(rc *RemoteConfig) {
+		provider, err := kubernetes.NewInClusterProvider(ctx, namespace)
+		if err != nil {
+			rc.err = fmt.Errorf("grc: connect to kubernetes failed: %w", err)
+			return
+		}
+		rc.provider = provider
+	})
+}
+
+func WithKubernetesClientProvider(ctx context.Context, client k8s.Interface, namespace string) Option {
 	return newFuncServerOption(func(rc *RemoteConfig) {
-		provider, err := etcd.NewProvider(ctx, endPoints, username, password)
+		provider, err := kubernetes.NewProvider(ctx, client, namespace)
 		if err != nil {
-			panic("grc: connect to etcd failed: " + err.Error())
+			rc.err = fmt.Errorf("grc: connect to kubernetes failed: %w", err)
+			return
 		}
 		rc.provider = provider
 	})
@@ -0,0 +1,116 @@
+// Package resolver implements the gRPC resolver of the grc discovery,
+// the target grc:///service resolves to the active nodes of the service.
+package resolver
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/appootb/grc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme of the grc targets.
+const Scheme = "grc"
+
+type weightKey struct{}
+
+type metadataKey struct{}
+
+// Weight returns the Node.Weight of the address resolved.
+func Weight(addr resolver.Address) int {
+	if addr.Attributes == nil {
+		return 0
+	}
+	weight, _ := addr.Attributes.Value(weightKey{}).(int)
+	return weight
+}
+
+// Metadata returns the Node.Metadata of the address resolved.
+func Metadata(addr resolver.Address) map[string]string {
+	if addr.Attributes == nil {
+		return nil
+	}
+	md, _ := addr.Attributes.Value(metadataKey{}).(map[string]string)
+	return md
+}
+
+// Register registers the builder of the rc globally, should be called in init.
+func Register(rc *grc.RemoteConfig) {
+	resolver.Register(NewBuilder(rc))
+}
+
+// NewBuilder returns the resolver builder of the rc, for grpc.WithResolvers.
+func NewBuilder(rc *grc.RemoteConfig) resolver.Builder {
+	return &builder{
+		rc: rc,
+	}
+}
+
+type builder struct {
+	rc *grc.RemoteConfig
+}
+
+func (b *builder) Scheme() string {
+	return Scheme
+}
+
+func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	r := &grcResolver{
+		cc:    cc,
+		nodes: grc.Nodes{},
+	}
+	// Fail fast instead of waiting for the first update.
+	if len(b.rc.GetNodes(target.Endpoint)) == 0 {
+		r.updateState()
+	}
+	r.stop = b.rc.WatchNodes(target.Endpoint, r.update)
+	return r, nil
+}
+
+type grcResolver struct {
+	cc   resolver.ClientConn
+	stop func()
+
+	mu    sync.Mutex
+	nodes grc.Nodes
+}
+
+// ResolveNow is a no-op, the updates are pushed by the discovery events.
+func (r *grcResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *grcResolver) Close() {
+	r.stop()
+}
+
+func (r *grcResolver) update(added, removed, updated grc.Nodes) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for addr := range removed {
+		delete(r.nodes, addr)
+	}
+	for addr, node := range added {
+		r.nodes[addr] = node
+	}
+	for addr, node := range updated {
+		r.nodes[addr] = node
+	}
+	r.updateState()
+}
+
+func (r *grcResolver) updateState() {
+	addrs := make([]resolver.Address, 0, len(r.nodes))
+	for _, node := range r.nodes.Active() {
+		addrs = append(addrs, resolver.Address{
+			Addr:       node.Address,
+			Attributes: attributes.New(weightKey{}, node.Weight, metadataKey{}, node.Metadata),
+		})
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].Addr < addrs[j].Addr
+	})
+	_ = r.cc.UpdateState(resolver.State{
+		Addresses: addrs,
+	})
+}
@@ -0,0 +1,135 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestRemoteConfig(t *testing.T) *grc.RemoteConfig {
+	rc, err := grc.New(grc.WithDebugProvider(), grc.WithBasePath("/resolver"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = rc.Close(context.Background())
+	})
+	return rc
+}
+
+// serve starts a health server on the bufconn, replying the address in the header.
+func serve(t *testing.T, addr string) *bufconn.Listener {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{},
+		_ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		_ = grpc.SetHeader(ctx, metadata.Pairs("node", addr))
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+	return lis
+}
+
+type testClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+}
+
+func (cc *testClientConn) UpdateState(state resolver.State) error {
+	cc.states <- state
+	return nil
+}
+
+func waitState(t *testing.T, cc *testClientConn) resolver.State {
+	select {
+	case state := <-cc.states:
+		return state
+	case <-time.After(time.Second * 3):
+		t.Fatal("resolver state timeout")
+		return resolver.State{}
+	}
+}
+
+func TestResolver_State(t *testing.T) {
+	rc := newTestRemoteConfig(t)
+	cc := &testClientConn{
+		states: make(chan resolver.State, 10),
+	}
+	r, err := NewBuilder(rc).Build(resolver.Target{Scheme: Scheme, Endpoint: "svc"}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if state := waitState(t, cc); len(state.Addresses) != 0 {
+		t.Fatal("actual:", state)
+	}
+
+	h, err := rc.RegisterNodeHandle("svc", "10.0.0.1:8080",
+		grc.WithNodeWeight(5), grc.WithNodeMetadata(map[string]string{"zone": "a"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := waitState(t, cc)
+	if len(state.Addresses) != 1 || state.Addresses[0].Addr != "10.0.0.1:8080" ||
+		Weight(state.Addresses[0]) != 5 || Metadata(state.Addresses[0])["zone"] != "a" {
+		t.Fatal("actual:", state)
+	}
+	// Draining nodes are removed.
+	if err = h.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	if state = waitState(t, cc); len(state.Addresses) != 0 {
+		t.Fatal("actual:", state)
+	}
+}
+
+func TestResolver_Dial(t *testing.T) {
+	rc := newTestRemoteConfig(t)
+	listeners := map[string]*bufconn.Listener{}
+	for _, addr := range []string{"bufnet-1", "bufnet-2"} {
+		listeners[addr] = serve(t, addr)
+		if _, err := rc.RegisterNode("health", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, Scheme+":///health",
+		grpc.WithInsecure(),
+		grpc.WithResolvers(NewBuilder(rc)),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return listeners[addr].Dial()
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	picked := map[string]bool{}
+	for i := 0; i < 20 && len(picked) < 2; i++ {
+		var md metadata.MD
+		_, err = client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true), grpc.Header(&md))
+		if err != nil {
+			t.Fatal(err)
+		}
+		picked[md.Get("node")[0]] = true
+	}
+	if !picked["bufnet-1"] || !picked["bufnet-2"] {
+		t.Fatal("actual:", picked)
+	}
+}
@@ -0,0 +1,267 @@
+package grc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ZoneLabel is the metadata key of the node zone, used by WithLocality.
+const ZoneLabel = "zone"
+
+var (
+	setRequirement = regexp.MustCompile(`^([\w./-]+)\s+(in|notin)\s*\(([^()]*)\)$`)
+	labelKey       = regexp.MustCompile(`^[\w./-]+$`)
+)
+
+// Selector matches the metadata of the nodes.
+type Selector interface {
+	Matches(md map[string]string) bool
+	String() string
+}
+
+type requirement struct {
+	key    string
+	op     string
+	values []string
+}
+
+func (r *requirement) matches(md map[string]string) bool {
+	v, ok := md[r.key]
+	switch r.op {
+	case "exists":
+		return ok
+	case "!":
+		return !ok
+	case "=", "in":
+		if !ok {
+			return false
+		}
+		for _, value := range r.values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	case "!=", "notin":
+		for _, value := range r.values {
+			if ok && v == value {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+type selector struct {
+	s    string
+	reqs []*requirement
+}
+
+func (s *selector) Matches(md map[string]string) bool {
+	for _, r := range s.reqs {
+		if !r.matches(md) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *selector) String() string {
+	return s.s
+}
+
+// ParseSelector parses the label selector, the requirements separated by commas are ANDed:
+// key=value, key==value, key!=value, key in (v1,v2), key notin (v1,v2), key, !key.
+func ParseSelector(s string) (Selector, error) {
+	sel := &selector{
+		s: s,
+	}
+	for _, term := range splitTerms(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		r, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		sel.reqs = append(sel.reqs, r)
+	}
+	return sel, nil
+}
+
+// splitTerms splits the selector by the commas outside the parentheses.
+func splitTerms(s string) []string {
+	var (
+		terms []string
+		depth int
+		start int
+	)
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func parseRequirement(term string) (*requirement, error) {
+	if m := setRequirement.FindStringSubmatch(term); m != nil {
+		r := &requirement{
+			key: m[1],
+			op:  m[2],
+		}
+		for _, v := range strings.Split(m[3], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				r.values = append(r.values, v)
+			}
+		}
+		if len(r.values) == 0 {
+			return nil, fmt.Errorf("grc: invalid selector %q, empty values", term)
+		}
+		return r, nil
+	}
+	for _, op := range []string{"!=", "==", "="} {
+		if i := strings.Index(term, op); i >= 0 {
+			key := strings.TrimSpace(term[:i])
+			if !labelKey.MatchString(key) {
+				return nil, fmt.Errorf("grc: invalid selector %q", term)
+			}
+			value := strings.TrimSpace(term[i+len(op):])
+			if op == "==" {
+				op = "="
+			}
+			return &requirement{
+				key:    key,
+				op:     op,
+				values: []string{value},
+			}, nil
+		}
+	}
+	op := "exists"
+	if strings.HasPrefix(term, "!") {
+		op = "!"
+		term = strings.TrimSpace(term[1:])
+	}
+	if !labelKey.MatchString(term) {
+		return nil, fmt.Errorf("grc: invalid selector %q", term)
+	}
+	return &requirement{
+		key: term,
+		op:  op,
+	}, nil
+}
+
+type queryOptions struct {
+	selectors []Selector
+	prefers   []Selector
+	statuses  []string
+	err       error
+}
+
+// QueryOption filters the nodes of GetNodes.
+type QueryOption func(*queryOptions)
+
+// WithSelector returns the nodes matching the label selector of ParseSelector.
+// No nodes are returned if the selector is invalid, GetNodesContext returns the parse error.
+func WithSelector(s string) QueryOption {
+	sel, err := ParseSelector(s)
+	if err != nil {
+		return func(o *queryOptions) {
+			if o.err == nil {
+				o.err = err
+			}
+		}
+	}
+	return WithLabelSelector(sel)
+}
+
+// WithLabelSelector returns the nodes matching the selector.
+func WithLabelSelector(sel Selector) QueryOption {
+	return func(o *queryOptions) {
+		o.selectors = append(o.selectors, sel)
+	}
+}
+
+// WithPreference returns the nodes matching the selector if any, otherwise all the nodes selected.
+func WithPreference(sel Selector) QueryOption {
+	return func(o *queryOptions) {
+		o.prefers = append(o.prefers, sel)
+	}
+}
+
+// WithLocality prefers the nodes in the zone, falls back to the other zones.
+func WithLocality(zone string) QueryOption {
+	return WithPreference(&selector{
+		s: ZoneLabel + "=" + zone,
+		reqs: []*requirement{
+			{
+				key:    ZoneLabel,
+				op:     "=",
+				values: []string{zone},
+			},
+		},
+	})
+}
+
+// filter returns the nodes selected by the options, or the error of the invalid options.
+func (n Nodes) filter(opts ...QueryOption) (Nodes, error) {
+	if len(opts) == 0 {
+		return n, nil
+	}
+	o := &queryOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.err != nil {
+		return Nodes{}, o.err
+	}
+	selected := n.match(o.selectors)
+	if len(o.statuses) > 0 {
+		selected = selected.withStatus(o.statuses)
+	}
+	// In order of the preferences.
+	for _, prefer := range o.prefers {
+		if preferred := selected.match([]Selector{prefer}); len(preferred) > 0 {
+			return preferred, nil
+		}
+	}
+	return selected, nil
+}
+
+func (n Nodes) withStatus(statuses []string) Nodes {
+	matched := make(Nodes, len(n))
+	for addr, node := range n {
+		for _, status := range statuses {
+			if node.status() == status {
+				matched[addr] = node
+				break
+			}
+		}
+	}
+	return matched
+}
+
+func (n Nodes) match(selectors []Selector) Nodes {
+	matched := make(Nodes, len(n))
+	for addr, node := range n {
+		ok := true
+		for _, sel := range selectors {
+			ok = ok && sel.Matches(node.Metadata)
+		}
+		if ok {
+			matched[addr] = node
+		}
+	}
+	return matched
+}
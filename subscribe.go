@@ -0,0 +1,195 @@
+package grc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/appootb/grc/backend"
+)
+
+// NodesEvent holds the changes of the nodes of a service,
+// the maps are shared among the subscribers and must not be modified.
+type NodesEvent struct {
+	Service string
+	Added   Nodes
+	Removed Nodes
+	Updated Nodes
+}
+
+// NodesFunc is invoked with the changes of the nodes of a service.
+type NodesFunc func(added, removed, updated Nodes)
+
+type nodesSubscriber struct {
+	service string
+	ctx     context.Context
+	fn      func(*NodesEvent)
+	exit    func()
+
+	mu     sync.Mutex
+	queue  []*NodesEvent
+	notify chan struct{}
+}
+
+func (s *nodesSubscriber) push(evt *NodesEvent) {
+	s.mu.Lock()
+	s.queue = append(s.queue, evt)
+	s.mu.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *nodesSubscriber) pop() []*NodesEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.queue
+	s.queue = nil
+	return queue
+}
+
+// WatchNodes invokes the fn with the changes of the nodes of the service,
+// the current nodes are passed as added first. The fn is invoked in order
+// in a dedicated goroutine, until stop is called or the RemoteConfig is stopped.
+func (rc *RemoteConfig) WatchNodes(service string, fn NodesFunc) (stop func()) {
+	ctx, cancel := context.WithCancel(rc.ctx)
+	rc.subscribe(&nodesSubscriber{
+		service: service,
+		ctx:     ctx,
+		fn: func(evt *NodesEvent) {
+			fn(evt.Added, evt.Removed, evt.Updated)
+		},
+		exit: cancel,
+	})
+	return cancel
+}
+
+// WatchNodesChan returns the channel of the changes of the nodes of the service,
+// the current nodes are sent as added first. The channel is closed when the ctx is done
+// or the RemoteConfig is stopped.
+func (rc *RemoteConfig) WatchNodesChan(ctx context.Context, service string) <-chan *NodesEvent {
+	ch := make(chan *NodesEvent, backend.DefaultChanLen)
+	rc.subscribe(&nodesSubscriber{
+		service: service,
+		ctx:     ctx,
+		fn: func(evt *NodesEvent) {
+			select {
+			case ch <- evt:
+			case <-ctx.Done():
+			case <-rc.ctx.Done():
+			}
+		},
+		exit: func() {
+			close(ch)
+		},
+	})
+	return ch
+}
+
+func (rc *RemoteConfig) subscribe(s *nodesSubscriber) {
+	s.notify = make(chan struct{}, 1)
+	if !rc.addRoutine() {
+		// Closed, the subscriber exits at once.
+		s.exit()
+		return
+	}
+
+	rc.subMu.Lock()
+	if nodes := rc.applyHealth(s.service, rc.loadNodes(s.service)); len(nodes) > 0 {
+		s.push(&NodesEvent{
+			Service: s.service,
+			Added:   nodes,
+			Removed: Nodes{},
+			Updated: Nodes{},
+		})
+	}
+	subs, ok := rc.subs[s.service]
+	if !ok {
+		subs = make(map[*nodesSubscriber]struct{})
+		rc.subs[s.service] = subs
+	}
+	subs[s] = struct{}{}
+	rc.subMu.Unlock()
+
+	go rc.runSubscriber(s)
+}
+
+func (rc *RemoteConfig) runSubscriber(s *nodesSubscriber) {
+	defer rc.wg.Done()
+	defer s.exit()
+	defer func() {
+		rc.subMu.Lock()
+		delete(rc.subs[s.service], s)
+		if len(rc.subs[s.service]) == 0 {
+			delete(rc.subs, s.service)
+		}
+		rc.subMu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-rc.ctx.Done():
+			return
+		case <-s.notify:
+			for _, evt := range s.pop() {
+				if s.ctx.Err() != nil || rc.ctx.Err() != nil {
+					return
+				}
+				s.fn(evt)
+			}
+		}
+	}
+}
+
+// storeService replaces the nodes of the service, and notifies the subscribers of the changes.
+func (rc *RemoteConfig) storeService(service string, svc Nodes) {
+	rc.subMu.Lock()
+	defer rc.subMu.Unlock()
+
+	// Diff the stored nodes, the statuses of CheckNodes are applied to the published ones.
+	prev := rc.loadNodes(service)
+	rc.svc.Store(service, svc)
+	subs := rc.subs[service]
+	if len(subs) == 0 {
+		return
+	}
+	evt := diffNodes(service, prev, svc)
+	if evt == nil {
+		return
+	}
+	evt.Added = rc.applyHealth(service, evt.Added)
+	evt.Removed = rc.applyHealth(service, evt.Removed)
+	evt.Updated = rc.applyHealth(service, evt.Updated)
+	for s := range subs {
+		s.push(evt)
+	}
+}
+
+// diffNodes returns the changes from prev to next, nil if not changed.
+func diffNodes(service string, prev, next Nodes) *NodesEvent {
+	evt := &NodesEvent{
+		Service: service,
+		Added:   Nodes{},
+		Removed: Nodes{},
+		Updated: Nodes{},
+	}
+	for addr, node := range next {
+		old, ok := prev[addr]
+		if !ok {
+			evt.Added[addr] = node
+		} else if old.String() != node.String() {
+			evt.Updated[addr] = node
+		}
+	}
+	for addr, node := range prev {
+		if _, ok := next[addr]; !ok {
+			evt.Removed[addr] = node
+		}
+	}
+	if len(evt.Added) == 0 && len(evt.Removed) == 0 && len(evt.Updated) == 0 {
+		return nil
+	}
+	return evt
+}
@@ -0,0 +1,256 @@
+// Package transport implements the http.RoundTripper of the grc discovery,
+// the requests to http://service/... are sent to the active nodes of the service.
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/appootb/grc"
+	"github.com/appootb/grc/balancer"
+)
+
+var (
+	// ErrNoNodes is returned if the service has no active nodes.
+	ErrNoNodes = balancer.ErrNoNodes
+)
+
+const (
+	DefaultRetries       = 2
+	DefaultEjectDuration = time.Second * 30
+)
+
+type Option func(*Transport)
+
+// WithBase sets the transport sending the requests rewritten, http.DefaultTransport by default.
+func WithBase(base http.RoundTripper) Option {
+	return func(t *Transport) {
+		t.base = base
+	}
+}
+
+// WithRetries sets the retries to the other nodes on connection failure.
+func WithRetries(retries int) Option {
+	return func(t *Transport) {
+		t.retries = retries
+	}
+}
+
+// WithEjectDuration sets how long the nodes failed to connect are not picked.
+func WithEjectDuration(d time.Duration) Option {
+	return func(t *Transport) {
+		t.ejectDuration = d
+	}
+}
+
+// WithPicker sets the picker of each service, balancer.NewRandom by default.
+// The path of the request is passed as the key of Pick.
+func WithPicker(fn func() balancer.Picker) Option {
+	return func(t *Transport) {
+		t.picker = fn
+	}
+}
+
+// Transport rewrites the host of the requests, the service name, to the address of a node
+// picked by the balancer of the service. It should only be used by the clients of the grc services.
+type Transport struct {
+	rc            *grc.RemoteConfig
+	base          http.RoundTripper
+	retries       int
+	ejectDuration time.Duration
+	picker        func() balancer.Picker
+
+	mu        sync.Mutex
+	balancers map[string]*serviceBalancer
+}
+
+type serviceBalancer struct {
+	*balancer.Balancer
+	picker *ejectPicker
+}
+
+// New returns the discovery-aware RoundTripper of the rc.
+func New(rc *grc.RemoteConfig, opts ...Option) *Transport {
+	t := &Transport{
+		rc:            rc,
+		base:          http.DefaultTransport,
+		retries:       DefaultRetries,
+		ejectDuration: DefaultEjectDuration,
+		picker: func() balancer.Picker {
+			return balancer.NewRandom(nil)
+		},
+		balancers: make(map[string]*serviceBalancer),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Close stops the balancers of the services.
+func (t *Transport) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for service, b := range t.balancers {
+		b.Close()
+		delete(t.balancers, service)
+	}
+}
+
+// RoundTrip implements http.RoundTripper, the requests failed to connect are retried
+// to the other nodes if the body can be sent again.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := t.balancer(req.URL.Hostname())
+	tried := map[string]bool{}
+	var lastErr error
+	for i := 0; ; i++ {
+		node, done, err := b.Pick(req.URL.Path)
+		if err == nil && tried[node.Address] {
+			// The nodes not tried are all ejected.
+			done()
+			err = ErrNoNodes
+		}
+		if err != nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			closeBody(req)
+			return nil, err
+		}
+		tried[node.Address] = true
+
+		outReq := req.Clone(req.Context())
+		outReq.URL.Host = node.Address
+		if i > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				done()
+				return nil, err
+			}
+			outReq.Body = body
+		}
+		resp, err := t.base.RoundTrip(outReq)
+		if err == nil {
+			resp.Body = &doneBody{ReadCloser: resp.Body, done: done}
+			return resp, nil
+		}
+		done()
+		if !isConnectionFailure(err) {
+			return nil, err
+		}
+		b.picker.eject(node.Address, t.ejectDuration)
+		if i >= t.retries || req.Body != nil && req.GetBody == nil {
+			return nil, err
+		}
+		lastErr = err
+	}
+}
+
+// balancer returns the balancer of the service, created on the first request.
+func (t *Transport) balancer(service string) *serviceBalancer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if b, ok := t.balancers[service]; ok {
+		return b
+	}
+	picker := &ejectPicker{
+		Picker:  t.picker(),
+		nodes:   grc.Nodes{},
+		ejected: make(map[string]time.Time),
+	}
+	b := &serviceBalancer{
+		Balancer: balancer.New(t.rc, service, picker),
+		picker:   picker,
+	}
+	t.balancers[service] = b
+	return b
+}
+
+// ejectPicker hides the nodes ejected from the picker, until the ejection expires
+// or no other nodes are left.
+type ejectPicker struct {
+	balancer.Picker
+
+	mu      sync.Mutex
+	nodes   grc.Nodes
+	ejected map[string]time.Time
+	expiry  time.Time
+}
+
+func (p *ejectPicker) Update(nodes grc.Nodes) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nodes = nodes
+	p.update(time.Now())
+}
+
+func (p *ejectPicker) Pick(key string) (*grc.Node, balancer.Done, error) {
+	p.mu.Lock()
+	if now := time.Now(); !p.expiry.IsZero() && !now.Before(p.expiry) {
+		p.update(now)
+	}
+	p.mu.Unlock()
+	return p.Picker.Pick(key)
+}
+
+func (p *ejectPicker) eject(addr string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	p.ejected[addr] = now.Add(d)
+	p.update(now)
+}
+
+// update passes the nodes not ejected to the picker, or all the nodes if every node is ejected.
+func (p *ejectPicker) update(now time.Time) {
+	p.expiry = time.Time{}
+	healthy := grc.Nodes{}
+	for addr, node := range p.nodes {
+		until, ok := p.ejected[addr]
+		if !ok || !now.Before(until) {
+			delete(p.ejected, addr)
+			healthy[addr] = node
+			continue
+		}
+		if p.expiry.IsZero() || until.Before(p.expiry) {
+			p.expiry = until
+		}
+	}
+	for addr := range p.ejected {
+		if _, ok := p.nodes[addr]; !ok {
+			delete(p.ejected, addr)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = p.nodes
+	}
+	p.Picker.Update(healthy)
+}
+
+// doneBody invokes the done of the node picked when the response body is closed.
+type doneBody struct {
+	io.ReadCloser
+	once sync.Once
+	done balancer.Done
+}
+
+func (b *doneBody) Close() error {
+	b.once.Do(b.done)
+	return b.ReadCloser.Close()
+}
+
+// isConnectionFailure returns if the request is not sent, the connection failed.
+func isConnectionFailure(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
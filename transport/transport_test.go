@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appootb/grc"
+	"github.com/appootb/grc/balancer"
+)
+
+func newTestRemoteConfig(t *testing.T) *grc.RemoteConfig {
+	rc, err := grc.New(grc.WithDebugProvider(), grc.WithBasePath("/transport"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = rc.Close(context.Background())
+	})
+	return rc
+}
+
+// closedAddr returns an address refusing the connections.
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	return addr
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write([]byte(r.Host + " " + r.URL.Path + " " + string(body)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// countTransport counts the requests sent to each address.
+type countTransport struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *countTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.counts[req.URL.Host]++
+	c.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestTransport_Retry(t *testing.T) {
+	rc := newTestRemoteConfig(t)
+	srv := newTestServer(t)
+	bad := closedAddr(t)
+	good := strings.TrimPrefix(srv.URL, "http://")
+	// The bad node is picked first mostly.
+	if _, err := rc.RegisterNode("svc", bad, grc.WithNodeWeight(100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.RegisterNode("svc", good); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.GetNodesContext(context.Background(), "svc"); err != nil {
+		t.Fatal(err)
+	}
+
+	counter := &countTransport{
+		counts: map[string]int{},
+	}
+	client := &http.Client{
+		Transport: New(rc, WithBase(counter), WithEjectDuration(time.Minute)),
+	}
+	for i := 0; i < 10; i++ {
+		resp, err := client.Post("http://svc/echo", "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != "svc /echo hello" {
+			t.Fatal("actual:", string(body))
+		}
+	}
+	// Ejected after the first failure.
+	if counter.counts[bad] > 1 || counter.counts[good] != 10 {
+		t.Fatal("actual:", counter.counts)
+	}
+}
+
+func TestTransport_NoNodes(t *testing.T) {
+	rc := newTestRemoteConfig(t)
+	client := &http.Client{
+		Transport: New(rc),
+	}
+	_, err := client.Get("http://unknown/")
+	if err == nil || !strings.Contains(err.Error(), ErrNoNodes.Error()) {
+		t.Fatal("actual:", err)
+	}
+	// Failed without the healthy nodes to retry.
+	if _, err = rc.RegisterNode("svc", closedAddr(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rc.GetNodesContext(context.Background(), "svc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Get("http://svc/"); err == nil || !isConnectionFailure(err) {
+		t.Fatal("actual:", err)
+	}
+}
+
+func TestTransport_Picker(t *testing.T) {
+	rc := newTestRemoteConfig(t)
+	srv1, srv2 := newTestServer(t), newTestServer(t)
+	for _, srv := range []*httptest.Server{srv1, srv2} {
+		if _, err := rc.RegisterNode("svc", strings.TrimPrefix(srv.URL, "http://")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := rc.GetNodesContext(context.Background(), "svc"); err != nil {
+		t.Fatal(err)
+	}
+
+	counter := &countTransport{
+		counts: map[string]int{},
+	}
+	tr := New(rc, WithBase(counter), WithPicker(balancer.NewRoundRobin))
+	defer tr.Close()
+	client := &http.Client{
+		Transport: tr,
+	}
+	for i := 0; i < 10; i++ {
+		resp, err := client.Get("http://svc/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+	if counter.counts[strings.TrimPrefix(srv1.URL, "http://")] != 5 ||
+		counter.counts[strings.TrimPrefix(srv2.URL, "http://")] != 5 {
+		t.Fatal("actual:", counter.counts)
+	}
+}